@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xsynch/chirpy/internal/auth"
+	"github.com/xsynch/chirpy/internal/database"
+)
+
+const defaultDeletionGrace = 30 * 24 * time.Hour
+
+// deleteAccount schedules the caller's account for deletion. It is logged
+// out everywhere straight away but nothing is removed until the grace
+// period has passed, so restoreAccount can still undo it.
+func (cfg *apiConfig) deleteAccount(w http.ResponseWriter, r *http.Request){
+	userID := authUserID(r)
+	request := struct {
+		Password string `json:"password"`
+	}{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&request)
+	if err != nil || request.Password == "" {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Password is required"))
+		return
+	}
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error looking up user %v: %s", userID, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Invalid User"))
+		return
+	}
+	err = auth.CheckPasswordHash(request.Password, user.HashedPassword)
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Incorrect password"))
+		return
+	}
+	if !user.DeletedAt.Valid {
+		user, err = cfg.db.ScheduleUserDeletion(r.Context(), userID)
+		if err != nil {
+			log.Printf("Error scheduling deletion of %v: %s", userID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Server Error, please try again"))
+			return
+		}
+	}
+	err = cfg.db.RevokeUserRefreshTokens(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error revoking refresh tokens for %v: %s", userID, err)
+	}
+	deletion := accountDeletion{
+		DeletionRequestedAt: user.DeletedAt.Time,
+		PurgeAfter: user.DeletedAt.Time.Add(cfg.deletion_grace),
+	}
+	dst, err := json.Marshal(deletion)
+	if err != nil {
+		log.Printf("Error marshalling %v: %s", deletion, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(dst)
+}
+
+// restoreAccount cancels a pending deletion. It takes credentials rather
+// than a token because login is refused while deletion is pending. With two
+// factor on, the password gets a login challenge back and the account is
+// restored when the challenge token and a code are posted here.
+func (cfg *apiConfig) restoreAccount(w http.ResponseWriter, r *http.Request){
+	request := struct {
+		chirpUser
+		challengeResponse
+	}{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&request)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Data Error"))
+		return
+	}
+	var user database.User
+	if request.ChallengeToken != "" {
+		var ok bool
+		user, ok = cfg.redeemLoginChallenge(w, r, request.challengeResponse)
+		if !ok {
+			return
+		}
+	} else {
+		user, err = cfg.db.LookupUser(r.Context(), request.Email)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Could not lookup user %s: %s", request.Email, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Server Error, please try again"))
+			return
+		}
+		if err != nil || auth.CheckPasswordHash(request.Password, user.HashedPassword) != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Incorrect email or password"))
+			return
+		}
+	}
+	if !user.DeletedAt.Valid {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Account is not scheduled for deletion"))
+		return
+	}
+	if request.ChallengeToken == "" && user.TotpEnabledAt.Valid {
+		cfg.startLoginChallenge(w, r, user)
+		return
+	}
+	user, err = cfg.db.RestoreUser(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("Error restoring user %v: %s", user.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	dbuser := createDBUserResponse{
+		ID: user.ID,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+		Email: user.Email,
+		IsChirpyRd: user.IsChirpyRed,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+	}
+	dst, err := json.Marshal(dbuser)
+	if err != nil {
+		log.Printf("Error marshalling %v: %s", dbuser, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dst)
+}
+
+func (cfg *apiConfig) purgeDeletedAccounts(ctx context.Context) error {
+	userIDs, err := cfg.db.GetUsersDueForPurge(ctx, int64(cfg.deletion_grace.Seconds()))
+	if err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		err = cfg.purgeAccount(ctx, userID)
+		if err != nil {
+			log.Printf("Error purging user %v: %s", userID, err)
+		}
+	}
+	return nil
+}
+
+// purgeAccount deletes the user's uploaded files and exports and then the
+// user row,
+// which cascades to everything else they own. Files go first so a failure
+// leaves the row in place to retry on the next run.
+func (cfg *apiConfig) purgeAccount(ctx context.Context, userID uuid.UUID) error {
+	keys, err := cfg.db.GetUserStorageKeys(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		err = cfg.media.Delete(ctx, key)
+		if err != nil {
+			return err
+		}
+	}
+	exportKeys, err := cfg.db.GetUserExportKeys(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, key := range exportKeys {
+		err = cfg.exports.Delete(ctx, key)
+		if err != nil {
+			return err
+		}
+	}
+	return cfg.db.PurgeUser(ctx, userID)
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xsynch/chirpy/internal/database"
+)
+
+// A thread shows replies this many levels deep, and at most this many of
+// them, oldest first.
+const maxThreadDepth = 10
+const maxThreadReplies = 200
+
+// chirpColumns are the chirp columns the listing queries select. They leave
+// out search_vector, so sqlc gives each of those queries its own row type
+// with exactly these fields.
+type chirpColumns = struct {
+	ID uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Body string
+	UserID uuid.UUID
+	InReplyTo uuid.NullUUID
+	QuotedChirpID uuid.NullUUID
+}
+
+func chirpFromColumns(c chirpColumns) database.Chirp {
+	return database.Chirp{
+		ID: c.ID,
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
+		Body: c.Body,
+		UserID: c.UserID,
+		InReplyTo: c.InReplyTo,
+		QuotedChirpID: c.QuotedChirpID,
+	}
+}
+
+func chirpsFromRows[T ~chirpColumns](rows []T) []database.Chirp {
+	chirps := make([]database.Chirp, 0, len(rows))
+	for _, val := range rows {
+		chirps = append(chirps, chirpFromColumns(chirpColumns(val)))
+	}
+	return chirps
+}
+
+// buildChirpResponses converts database rows into the JSON shape returned by
+// every chirp endpoint, filling in the per-chirp counts in batched queries.
+// viewerID is the logged in user, or uuid.Nil for anonymous requests.
+func (cfg *apiConfig) buildChirpResponses(ctx context.Context, chirps []database.Chirp, viewerID uuid.UUID) ([]chirpSuccess, error){
+	responses, err := cfg.buildFlatChirpResponses(ctx, chirps, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	// quoted chirps are embedded one level deep, a quote of a quote only
+	// carries the quoted_chirp_id
+	quotedIDs := []uuid.UUID{}
+	for _, val := range chirps {
+		if val.QuotedChirpID.Valid {
+			quotedIDs = append(quotedIDs, val.QuotedChirpID.UUID)
+		}
+	}
+	if len(quotedIDs) == 0 {
+		return responses, nil
+	}
+	quotedRows, err := cfg.db.GetChirpsByIDs(ctx, quotedIDs)
+	if err != nil {
+		return nil, err
+	}
+	quoted, err := cfg.buildFlatChirpResponses(ctx, chirpsFromRows(quotedRows), viewerID)
+	if err != nil {
+		return nil, err
+	}
+	quotedByID := map[uuid.UUID]chirpSuccess{}
+	for _, val := range quoted {
+		quotedByID[val.ID] = val
+	}
+	for idx, val := range chirps {
+		if !val.QuotedChirpID.Valid {
+			continue
+		}
+		if q, ok := quotedByID[val.QuotedChirpID.UUID]; ok {
+			responses[idx].QuotedChirp = &q
+		}
+	}
+	return responses, nil
+}
+
+func (cfg *apiConfig) buildFlatChirpResponses(ctx context.Context, chirps []database.Chirp, viewerID uuid.UUID) ([]chirpSuccess, error){
+	ids := make([]uuid.UUID, 0, len(chirps))
+	for _, val := range chirps {
+		ids = append(ids, val.ID)
+	}
+	replyCounts := map[uuid.UUID]int64{}
+	if len(ids) > 0 {
+		counts, err := cfg.db.GetReplyCounts(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, val := range counts {
+			replyCounts[val.ChirpID] = val.ReplyCount
+		}
+	}
+	likeCounts := map[uuid.UUID]int64{}
+	likedByViewer := map[uuid.UUID]bool{}
+	if len(ids) > 0 {
+		counts, err := cfg.db.GetLikeCounts(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, val := range counts {
+			likeCounts[val.ChirpID] = val.LikeCount
+		}
+		if viewerID != uuid.Nil {
+			liked, err := cfg.db.GetLikedChirpIDs(ctx, database.GetLikedChirpIDsParams{UserID: viewerID, ChirpIds: ids})
+			if err != nil {
+				return nil, err
+			}
+			for _, val := range liked {
+				likedByViewer[val] = true
+			}
+		}
+	}
+	entities := map[uuid.UUID][]chirpEntity{}
+	if len(ids) > 0 {
+		results, err := cfg.db.GetEntitiesForChirps(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, val := range results {
+			entity := chirpEntity{
+				Type: val.EntityType,
+				Text: val.Text,
+				Start: int(val.StartOffset),
+				End: int(val.EndOffset),
+			}
+			if val.UserID.Valid {
+				mentioned := val.UserID.UUID
+				entity.UserID = &mentioned
+			}
+			entities[val.ChirpID] = append(entities[val.ChirpID], entity)
+		}
+	}
+	media := map[uuid.UUID][]chirpMedia{}
+	if len(ids) > 0 {
+		results, err := cfg.db.GetMediaForChirps(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		mediaIDs := make([]uuid.UUID, 0, len(results))
+		for _, val := range results {
+			mediaIDs = append(mediaIDs, val.ID)
+		}
+		variants := []database.MediaVariant{}
+		if len(mediaIDs) > 0 {
+			variants, err = cfg.db.GetMediaVariants(ctx, mediaIDs)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, val := range results {
+			media[val.ChirpID.UUID] = append(media[val.ChirpID.UUID], cfg.mediaResponse(val, variants))
+		}
+	}
+
+	authorIDs := make([]uuid.UUID, 0, len(chirps))
+	for _, val := range chirps {
+		authorIDs = append(authorIDs, val.UserID)
+	}
+	authors, err := cfg.authorsByID(ctx, authorIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]chirpSuccess, 0, len(chirps))
+	for _, val := range chirps {
+		cs := chirpSuccess{
+			ID: val.ID,
+			CreatedAt: val.CreatedAt,
+			UpdatedAt: val.UpdatedAt,
+			Body: val.Body,
+			Author: authors[val.UserID],
+			ReplyCount: replyCounts[val.ID],
+			LikeCount: likeCounts[val.ID],
+			LikedByMe: likedByViewer[val.ID],
+			Entities: entities[val.ID],
+		}
+		if cs.Entities == nil {
+			cs.Entities = []chirpEntity{}
+		}
+		cs.Media = media[val.ID]
+		if cs.Media == nil {
+			cs.Media = []chirpMedia{}
+		}
+		if val.InReplyTo.Valid {
+			parentID := val.InReplyTo.UUID
+			cs.InReplyTo = &parentID
+		}
+		if val.QuotedChirpID.Valid {
+			quotedID := val.QuotedChirpID.UUID
+			cs.QuotedChirpID = &quotedID
+		}
+		cfg.filterChirpResponse(&cs)
+		responses = append(responses, cs)
+	}
+	return responses, nil
+}
+
+func (cfg *apiConfig) buildChirpResponse(ctx context.Context, chirp database.Chirp, viewerID uuid.UUID) (chirpSuccess, error){
+	responses, err := cfg.buildChirpResponses(ctx, []database.Chirp{chirp}, viewerID)
+	if err != nil {
+		return chirpSuccess{}, err
+	}
+	return responses[0], nil
+}
+
+// optionalUserID returns the user behind a valid bearer token, or uuid.Nil
+// when the request is anonymous. Public endpoints use it to personalise
+// responses without requiring a login.
+func (cfg *apiConfig) optionalUserID(r *http.Request) uuid.UUID{
+	caller, err := cfg.authenticate(r)
+	if err != nil || !caller.has(scopeChirpsRead) {
+		return uuid.Nil
+	}
+	return caller.UserID
+}
+
+// getChirpThread returns the ancestors of a chirp, oldest first, followed by
+// the chirp itself and its replies nested below it.
+func (cfg *apiConfig) getChirpThread(w http.ResponseWriter, r *http.Request){
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid chirp id"))
+		return
+	}
+	chirp, err := cfg.db.GetOneChirp(r.Context(), chirpID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Error finding chirp"))
+		log.Printf("There was an error getting chirp %v: %s", chirpID, err)
+		return
+	}
+	ancestorRows, err := cfg.db.GetChirpAncestors(r.Context(), chirpID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error getting thread"))
+		log.Printf("There was an error getting ancestors of %v: %s", chirpID, err)
+		return
+	}
+	// one more row than is shown tells us the thread was cut short
+	descendantRows, err := cfg.db.GetChirpDescendants(r.Context(), database.GetChirpDescendantsParams{ParentID: chirpID, MaxDepth: maxThreadDepth, MaxRows: maxThreadReplies + 1})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error getting thread"))
+		log.Printf("There was an error getting replies to %v: %s", chirpID, err)
+		return
+	}
+
+	truncated := len(descendantRows) > maxThreadReplies
+	if truncated {
+		descendantRows = descendantRows[:maxThreadReplies]
+	}
+
+	ancestors := chirpsFromRows(ancestorRows)
+	all := append(append(ancestors, chirp), chirpsFromRows(descendantRows)...)
+	responses, err := cfg.buildChirpResponses(r.Context(), all, cfg.optionalUserID(r))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error getting thread"))
+		log.Printf("There was an error building thread for %v: %s", chirpID, err)
+		return
+	}
+
+	// descendants come back oldest first, so every parent is seen before its replies
+	nodes := map[uuid.UUID]*threadNode{}
+	root := &threadNode{chirpSuccess: responses[len(ancestors)], Replies: []*threadNode{}}
+	nodes[root.ID] = root
+	for _, val := range responses[len(ancestors)+1:] {
+		node := &threadNode{chirpSuccess: val, Replies: []*threadNode{}}
+		nodes[node.ID] = node
+		if parent, ok := nodes[*val.InReplyTo]; ok {
+			parent.Replies = append(parent.Replies, node)
+		}
+	}
+	thread := chirpThread{Ancestors: responses[:len(ancestors)], Chirp: root, Truncated: truncated}
+
+	dst, err := json.Marshal(thread)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error getting thread"))
+		log.Printf("Error marshalling %v: %s", thread, err)
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dst)
+}
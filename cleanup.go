@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// runCleanup runs the periodic housekeeping jobs once per interval until
+// ctx is cancelled.
+func (cfg *apiConfig) runCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := cfg.rotateSigningKeys(ctx)
+		if err != nil {
+			log.Printf("Error rotating signing keys: %s", err)
+		}
+		err = cfg.purgeDeletedAccounts(ctx)
+		if err != nil {
+			log.Printf("Error purging deleted accounts: %s", err)
+		}
+		err = cfg.failStaleExports(ctx)
+		if err != nil {
+			log.Printf("Error failing stale exports: %s", err)
+		}
+		err = cfg.purgeExpiredExports(ctx)
+		if err != nil {
+			log.Printf("Error purging expired exports: %s", err)
+		}
+		err = cfg.purgeAbandonedMedia(ctx)
+		if err != nil {
+			log.Printf("Error purging abandoned media: %s", err)
+		}
+		purged, err := cfg.db.PurgeRefreshTokens(ctx)
+		if err != nil {
+			log.Printf("Error purging refresh tokens: %s", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d expired or revoked refresh tokens", purged)
+		}
+		err = cfg.db.PurgeOAuthTokens(ctx)
+		if err != nil {
+			log.Printf("Error purging oauth tokens: %s", err)
+		}
+		err = cfg.db.PurgeOAuthCodes(ctx)
+		if err != nil {
+			log.Printf("Error purging oauth codes: %s", err)
+		}
+		err = cfg.db.PurgeLoginChallenges(ctx)
+		if err != nil {
+			log.Printf("Error purging login challenges: %s", err)
+		}
+		err = cfg.db.PurgeOIDCLoginStates(ctx)
+		if err != nil {
+			log.Printf("Error purging oidc login states: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
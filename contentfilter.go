@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/xsynch/chirpy/internal/auth"
+	"github.com/xsynch/chirpy/internal/database"
+	"github.com/xsynch/chirpy/internal/filter"
+)
+
+const defaultFilterWords = "kerfuffle:mask,sharbert:mask,fornax:mask"
+
+// reloadFilterWords rebuilds the word list from FILTER_WORDS plus the words
+// stored through the admin API. A stored word overrides the configured
+// action for the same word.
+func (cfg *apiConfig) reloadFilterWords(ctx context.Context) error{
+	stored, err := cfg.db.GetFilterWords(ctx)
+	if err != nil {
+		return err
+	}
+	rules := append([]filter.Rule{}, cfg.filterRules...)
+	for _, val := range stored {
+		action, err := filter.ParseAction(val.Action)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, filter.Rule{Word: val.Word, Action: action})
+	}
+	cfg.filterWords.Replace(rules)
+	return nil
+}
+
+func (cfg *apiConfig) flagChirp(ctx context.Context, chirpID uuid.UUID, result filter.Result) error{
+	params := database.FlagChirpForReviewParams{
+		ChirpID: chirpID,
+		MatchedWords: strings.Join(result.Matches, ","),
+	}
+	return cfg.db.FlagChirpForReview(ctx, params)
+}
+
+// filterChirpResponse runs a stored chirp through the current word list, so
+// words added after it was written apply on every read. A chirp the list now
+// rejects is withheld along with its media and quote. When masking changed the body its entities are found
+// again, the stored offsets point into the body as it was written.
+func (cfg *apiConfig) filterChirpResponse(cs *chirpSuccess){
+	result := cfg.contentFilter.Check(cs.Body)
+	if result.Rejected {
+		cs.Body = ""
+		cs.Withheld = true
+		cs.Entities = []chirpEntity{}
+		cs.Media = []chirpMedia{}
+		cs.QuotedChirpID = nil
+		return
+	}
+	if result.Body == cs.Body {
+		return
+	}
+	mentioned := map[string]*uuid.UUID{}
+	for _, val := range cs.Entities {
+		if val.Type == entityMention {
+			mentioned[val.Text] = val.UserID
+		}
+	}
+	cs.Body = result.Body
+	cs.Entities = []chirpEntity{}
+	for _, val := range extractEntities(result.Body) {
+		entity := chirpEntity{Type: val.Type, Text: val.Text, Start: val.Start, End: val.End}
+		if val.Type == entityMention {
+			entity.UserID = mentioned[val.Text]
+		}
+		cs.Entities = append(cs.Entities, entity)
+	}
+}
+
+// isAdmin checks the ApiKey authorization header against ADMIN_KEY. The
+// filter admin API is disabled when no key is configured.
+func (cfg *apiConfig) isAdmin(r *http.Request) bool{
+	if cfg.admin_key == "" {
+		return false
+	}
+	authKey, err := auth.GetAPIKey(r.Header)
+	if err != nil {
+		return false
+	}
+	return authKey == cfg.admin_key
+}
+
+func (cfg *apiConfig) getFilterWords(w http.ResponseWriter, r *http.Request){
+	if !cfg.isAdmin(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Error getting authorization key"))
+		return
+	}
+	stored, err := cfg.db.GetFilterWords(r.Context())
+	if err != nil {
+		log.Printf("Error getting filter words: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error getting filter words"))
+		return
+	}
+	words := []filterWord{}
+	for _, val := range cfg.filterRules {
+		words = append(words, filterWord{Word: val.Word, Action: string(val.Action), Source: "config"})
+	}
+	for _, val := range stored {
+		words = append(words, filterWord{Word: val.Word, Action: val.Action, Source: "admin"})
+	}
+	dst, err := json.Marshal(words)
+	if err != nil {
+		log.Printf("Error marshalling %v: %s", words, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error getting filter words"))
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dst)
+}
+
+func (cfg *apiConfig) putFilterWord(w http.ResponseWriter, r *http.Request){
+	if !cfg.isAdmin(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Error getting authorization key"))
+		return
+	}
+	word := filterWord{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&word)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Data Error"))
+		return
+	}
+	word.Word = filter.Normalize(strings.TrimSpace(word.Word))
+	if word.Word == "" || strings.ContainsFunc(word.Word, unicode.IsSpace) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Filter words must be a single word"))
+		return
+	}
+	action, err := filter.ParseAction(word.Action)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	stored, err := cfg.db.UpsertFilterWord(r.Context(), database.UpsertFilterWordParams{Word: word.Word, Action: string(action)})
+	if err != nil {
+		log.Printf("Error saving filter word %s: %s", word.Word, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error saving filter word"))
+		return
+	}
+	err = cfg.reloadFilterWords(r.Context())
+	if err != nil {
+		log.Printf("Error reloading filter words: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error saving filter word"))
+		return
+	}
+	dst, err := json.Marshal(filterWord{Word: stored.Word, Action: stored.Action, Source: "admin"})
+	if err != nil {
+		log.Printf("Error marshalling %v: %s", stored, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dst)
+}
+
+func (cfg *apiConfig) deleteFilterWord(w http.ResponseWriter, r *http.Request){
+	if !cfg.isAdmin(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Error getting authorization key"))
+		return
+	}
+	word := filter.Normalize(r.PathValue("word"))
+	err := cfg.db.DeleteFilterWord(r.Context(), word)
+	if err != nil {
+		log.Printf("Error deleting filter word %s: %s", word, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error deleting filter word"))
+		return
+	}
+	err = cfg.reloadFilterWords(r.Context())
+	if err != nil {
+		log.Printf("Error reloading filter words: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error deleting filter word"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) getPendingReviews(w http.ResponseWriter, r *http.Request){
+	if !cfg.isAdmin(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Error getting authorization key"))
+		return
+	}
+	results, err := cfg.db.GetChirpsPendingReview(r.Context())
+	if err != nil {
+		log.Printf("Error getting chirps pending review: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error getting reviews"))
+		return
+	}
+	reviews := []chirpReview{}
+	for _, val := range results {
+		// moderators need to see what was actually written
+		reviews = append(reviews, chirpReview{
+			ChirpID: val.ID,
+			UserID: val.UserID,
+			Body: val.Body,
+			MatchedWords: strings.Split(val.MatchedWords, ","),
+			FlaggedAt: val.FlaggedAt,
+		})
+	}
+	dst, err := json.Marshal(reviews)
+	if err != nil {
+		log.Printf("Error marshalling %v: %s", reviews, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error getting reviews"))
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dst)
+}
+
+func (cfg *apiConfig) resolveReview(w http.ResponseWriter, r *http.Request){
+	if !cfg.isAdmin(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Error getting authorization key"))
+		return
+	}
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid chirp id"))
+		return
+	}
+	err = cfg.db.ResolveChirpReview(r.Context(), chirpID)
+	if err != nil {
+		log.Printf("Error resolving review of %v: %s", chirpID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error resolving review"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
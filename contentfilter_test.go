@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/xsynch/chirpy/internal/filter"
+)
+
+func TestFilterChirpResponse(t *testing.T) {
+	alice := uuid.New()
+	quoted := uuid.New()
+	cfg := &apiConfig{contentFilter: filter.Pipeline{filter.NewWordList([]filter.Rule{
+		{Word: "kerfuffle", Action: filter.Mask},
+		{Word: "sharbert", Action: filter.Reject},
+	})}}
+	tests := []struct {
+		name string
+		in   chirpSuccess
+		want chirpSuccess
+	}{
+		{
+			name: "unchanged body keeps stored entities",
+			in: chirpSuccess{Body: "hi @alice", Entities: []chirpEntity{
+				{Type: entityMention, Text: "@alice", Start: 3, End: 9, UserID: &alice},
+			}},
+			want: chirpSuccess{Body: "hi @alice", Entities: []chirpEntity{
+				{Type: entityMention, Text: "@alice", Start: 3, End: 9, UserID: &alice},
+			}},
+		},
+		{
+			name: "masking moves the entity offsets",
+			in: chirpSuccess{Body: "a kerfuffle with @alice #news", Entities: []chirpEntity{
+				{Type: entityMention, Text: "@alice", Start: 17, End: 23, UserID: &alice},
+				{Type: entityHashtag, Text: "#news", Start: 24, End: 29},
+			}},
+			want: chirpSuccess{Body: "a **** with @alice #news", Entities: []chirpEntity{
+				{Type: entityMention, Text: "@alice", Start: 12, End: 18, UserID: &alice},
+				{Type: entityHashtag, Text: "#news", Start: 19, End: 24},
+			}},
+		},
+		{
+			name: "masked hashtag is dropped",
+			in: chirpSuccess{Body: "#kerfuffle", Entities: []chirpEntity{
+				{Type: entityHashtag, Text: "#kerfuffle", Start: 0, End: 10},
+			}},
+			want: chirpSuccess{Body: "#****", Entities: []chirpEntity{}},
+		},
+		{
+			name: "rejected body is withheld",
+			in: chirpSuccess{Body: "sharbert @alice", Entities: []chirpEntity{
+				{Type: entityMention, Text: "@alice", Start: 9, End: 15, UserID: &alice},
+			}},
+			want: chirpSuccess{Body: "", Withheld: true, Entities: []chirpEntity{}, Media: []chirpMedia{}},
+		},
+		{
+			name: "rejected chirp drops its media and quote",
+			in: chirpSuccess{Body: "sharbert", QuotedChirpID: &quoted, Entities: []chirpEntity{}, Media: []chirpMedia{
+				{ID: uuid.New(), URL: "/app/media/a.jpg"},
+			}},
+			want: chirpSuccess{Body: "", Withheld: true, Entities: []chirpEntity{}, Media: []chirpMedia{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.in
+			cfg.filterChirpResponse(&got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filterChirpResponse(%q) = %+v, want %+v", tt.in.Body, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/xsynch/chirpy/internal/database"
+)
+
+const (
+	entityHashtag = "hashtag"
+	entityMention = "mention"
+	entityURL = "url"
+)
+
+var urlPattern = regexp.MustCompile(`https?://[^\s]+`)
+var hashtagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&])(#[\p{L}\p{N}_]+)`)
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_])(@[\p{L}\p{N}_.+-]+(?:@[\p{L}\p{N}-]+(?:\.[\p{L}\p{N}-]+)+)?)`)
+
+// parsedEntity is a hashtag, mention or URL found in a chirp body. Start and
+// End are rune offsets, the same unit the 140 character limit is counted in.
+type parsedEntity struct {
+	Type string
+	Text string
+	Value string
+	Start int
+	End int
+}
+
+// extractEntities finds the hashtags, mentions and URLs in a chirp body,
+// ordered by where they start. Hashtags and mentions inside a URL are not
+// reported separately.
+func extractEntities(body string) []parsedEntity {
+	type span struct{ start, end int }
+	urls := []span{}
+	entities := []parsedEntity{}
+
+	for _, loc := range urlPattern.FindAllStringIndex(body, -1) {
+		text := strings.TrimRight(body[loc[0]:loc[1]], ".,!?;:)'\"")
+		end := loc[0] + len(text)
+		urls = append(urls, span{loc[0], end})
+		entities = append(entities, parsedEntity{Type: entityURL, Text: text, Value: text, Start: loc[0], End: end})
+	}
+	insideURL := func(start int) bool {
+		for _, val := range urls {
+			if start >= val.start && start < val.end {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, loc := range hashtagPattern.FindAllStringSubmatchIndex(body, -1) {
+		if insideURL(loc[2]) {
+			continue
+		}
+		text := body[loc[2]:loc[3]]
+		entities = append(entities, parsedEntity{Type: entityHashtag, Text: text, Value: normaliseHashtag(text), Start: loc[2], End: loc[3]})
+	}
+	for _, loc := range mentionPattern.FindAllStringSubmatchIndex(body, -1) {
+		if insideURL(loc[2]) {
+			continue
+		}
+		text := strings.TrimRight(body[loc[2]:loc[3]], ".")
+		end := loc[2] + len(text)
+		entities = append(entities, parsedEntity{Type: entityMention, Text: text, Value: strings.ToLower(strings.TrimPrefix(text, "@")), Start: loc[2], End: end})
+	}
+
+	sort.Slice(entities, func(i, j int) bool {
+		return entities[i].Start < entities[j].Start
+	})
+	// the regexps work in bytes, clients count characters
+	for idx, val := range entities {
+		entities[idx].Start = utf8.RuneCountInString(body[:val.Start])
+		entities[idx].End = utf8.RuneCountInString(body[:val.End])
+	}
+	return entities
+}
+
+func normaliseHashtag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(tag, "#"))
+}
+
+// resolveMention finds the user a mention refers to by handle. Mentions of
+// the form @user@example.com are kept as text but never resolved, looking
+// them up by email would tell anyone which addresses have an account.
+func (cfg *apiConfig) resolveMention(ctx context.Context, value string) (uuid.NullUUID, error){
+	if strings.Contains(value, "@") {
+		return uuid.NullUUID{}, nil
+	}
+	user, err := cfg.db.GetUserByHandle(ctx, value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return uuid.NullUUID{}, nil
+	}
+	if err != nil {
+		return uuid.NullUUID{}, err
+	}
+	return uuid.NullUUID{UUID: user.ID, Valid: true}, nil
+}
+
+// saveChirpEntities replaces the stored entities of a chirp with the ones
+// found in its current body. q is cfg.db, or the transaction the chirp was
+// written in.
+func (cfg *apiConfig) saveChirpEntities(ctx context.Context, q *database.Queries, chirp database.Chirp) error{
+	err := q.DeleteChirpEntities(ctx, chirp.ID)
+	if err != nil {
+		return err
+	}
+	for _, val := range extractEntities(chirp.Body) {
+		params := database.InsertChirpEntityParams{
+			ChirpID: chirp.ID,
+			EntityType: val.Type,
+			Text: val.Text,
+			Value: val.Value,
+			StartOffset: int32(val.Start),
+			EndOffset: int32(val.End),
+		}
+		if val.Type == entityMention {
+			params.UserID, err = cfg.resolveMention(ctx, val.Value)
+			if err != nil {
+				return err
+			}
+		}
+		err = q.InsertChirpEntity(ctx, params)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cfg *apiConfig) getHashtagChirps(w http.ResponseWriter, r *http.Request){
+	tag := normaliseHashtag(r.PathValue("tag"))
+	if tag == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid hashtag"))
+		return
+	}
+	page, err := parsePageRequest(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	params := database.GetChirpsByHashtagParams{Tag: tag, PageSize: int32(page.Limit + 1)}
+	params.CursorCreatedAt, params.CursorID = page.cursorArgs()
+	results, err := cfg.db.GetChirpsByHashtag(r.Context(), params)
+	if err != nil {
+		log.Printf("Error getting chirps tagged %s: %s", tag, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error getting chirps"))
+		return
+	}
+	cfg.writeChirpPage(w, r, chirpsFromRows(results), page.Limit)
+}
+
+func (cfg *apiConfig) getUserMentions(w http.ResponseWriter, r *http.Request){
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid user id"))
+		return
+	}
+	page, err := parsePageRequest(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	params := database.GetChirpsMentioningUserParams{UserID: uuid.NullUUID{UUID: userID, Valid: true}, PageSize: int32(page.Limit + 1)}
+	params.CursorCreatedAt, params.CursorID = page.cursorArgs()
+	results, err := cfg.db.GetChirpsMentioningUser(r.Context(), params)
+	if err != nil {
+		log.Printf("Error getting chirps mentioning %v: %s", userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error getting chirps"))
+		return
+	}
+	cfg.writeChirpPage(w, r, chirpsFromRows(results), page.Limit)
+}
+
+// writeChirpPage writes up to limit chirps, which were fetched with one
+// extra to find out whether there is another page.
+func (cfg *apiConfig) writeChirpPage(w http.ResponseWriter, r *http.Request, results []database.Chirp, limit int){
+	chirpsPage := chirpPage{}
+	if len(results) > limit {
+		results = results[:limit]
+		last := results[limit-1]
+		chirpsPage.NextCursor = encodeCursor(chirpCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+	chirps, err := cfg.buildChirpResponses(r.Context(), results, cfg.optionalUserID(r))
+	if err != nil {
+		log.Printf("Error building chirps: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error getting chirps"))
+		return
+	}
+	chirpsPage.Chirps = chirps
+	dst, err := json.Marshal(chirpsPage)
+	if err != nil {
+		log.Printf("Error marshalling %v: %s", chirpsPage, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error getting chirps"))
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dst)
+}
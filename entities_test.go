@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestExtractEntities(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []parsedEntity
+	}{
+		{
+			name: "plain text",
+			body: "nothing to see here",
+			want: []parsedEntity{},
+		},
+		{
+			name: "hashtag and mention",
+			body: "hi @Alice, loving #GoLang",
+			want: []parsedEntity{
+				{Type: entityMention, Text: "@Alice", Value: "alice", Start: 3, End: 9},
+				{Type: entityHashtag, Text: "#GoLang", Value: "golang", Start: 18, End: 25},
+			},
+		},
+		{
+			name: "offsets count runes not bytes",
+			body: "héllo wörld #café @zoë",
+			want: []parsedEntity{
+				{Type: entityHashtag, Text: "#café", Value: "café", Start: 12, End: 17},
+				{Type: entityMention, Text: "@zoë", Value: "zoë", Start: 18, End: 22},
+			},
+		},
+		{
+			name: "emoji before entity",
+			body: "🐦🐦 #chirp",
+			want: []parsedEntity{
+				{Type: entityHashtag, Text: "#chirp", Value: "chirp", Start: 3, End: 9},
+			},
+		},
+		{
+			name: "url swallows its fragment and trailing punctuation is dropped",
+			body: "see https://example.com/a#top, ok",
+			want: []parsedEntity{
+				{Type: entityURL, Text: "https://example.com/a#top", Value: "https://example.com/a#top", Start: 4, End: 29},
+			},
+		},
+		{
+			name: "federated mention",
+			body: "cc @bob@example.com.",
+			want: []parsedEntity{
+				{Type: entityMention, Text: "@bob@example.com", Value: "bob@example.com", Start: 3, End: 19},
+			},
+		},
+		{
+			name: "no hashtag inside a word",
+			body: "issue#12 and a&#39;b",
+			want: []parsedEntity{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractEntities(tt.body)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("extractEntities(%q)\n got %+v\nwant %+v", tt.body, got, tt.want)
+			}
+			runes := []rune(tt.body)
+			for _, val := range got {
+				if val.End > utf8.RuneCountInString(tt.body) || string(runes[val.Start:val.End]) != val.Text {
+					t.Errorf("offsets %d:%d do not select %q in %q", val.Start, val.End, val.Text, tt.body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xsynch/chirpy/internal/database"
+)
+
+const exportTTL = 7 * 24 * time.Hour
+const exportTimeout = 30 * time.Minute
+
+// requestExport starts building an archive of everything stored about the
+// caller. The archive is built in the background; poll getExport until it
+// is ready. A request while one is still pending returns the pending one.
+func (cfg *apiConfig) requestExport(w http.ResponseWriter, r *http.Request){
+	userID := authUserID(r)
+	export, err := cfg.db.GetPendingDataExport(r.Context(), database.GetPendingDataExportParams{
+		UserID: userID,
+		TimeoutSeconds: int64(exportTimeout.Seconds()),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		export, err = cfg.db.InsertDataExport(r.Context(), database.InsertDataExportParams{ID: uuid.New(), UserID: userID})
+		if err == nil {
+			go cfg.buildExport(export)
+		}
+	}
+	if err != nil {
+		log.Printf("Error starting export for %v: %s", userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	writeExportStatus(w, http.StatusAccepted, export)
+}
+
+// getExport reports the status of an export, or streams the archive once
+// it is ready.
+func (cfg *apiConfig) getExport(w http.ResponseWriter, r *http.Request){
+	userID := authUserID(r)
+	exportID, err := uuid.Parse(r.PathValue("exportID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid export id"))
+		return
+	}
+	export, err := cfg.db.GetDataExport(r.Context(), database.GetDataExportParams{ID: exportID, UserID: userID})
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Export not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Error looking up export %v: %s", exportID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	switch export.Status {
+	case "pending":
+		writeExportStatus(w, http.StatusAccepted, export)
+		return
+	case "ready":
+	default:
+		writeExportStatus(w, http.StatusOK, export)
+		return
+	}
+	archive, err := cfg.exports.Open(r.Context(), export.StorageKey.String)
+	if err != nil {
+		log.Printf("Error opening export %v: %s", exportID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	defer archive.Close()
+	w.Header().Set("Content-Type","application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="chirpy-export-%s.zip"`, export.CreatedAt.Format("2006-01-02")))
+	if export.SizeBytes.Valid {
+		w.Header().Set("Content-Length", fmt.Sprint(export.SizeBytes.Int64))
+	}
+	w.WriteHeader(http.StatusOK)
+	_, err = io.Copy(w, archive)
+	if err != nil {
+		log.Printf("Error sending export %v: %s", exportID, err)
+	}
+}
+
+func writeExportStatus(w http.ResponseWriter, code int, export database.DataExport){
+	status := dataExport{ID: export.ID, Status: export.Status, CreatedAt: export.CreatedAt}
+	if export.CompletedAt.Valid {
+		status.CompletedAt = &export.CompletedAt.Time
+	}
+	if export.ExpiresAt.Valid {
+		status.ExpiresAt = &export.ExpiresAt.Time
+	}
+	if export.SizeBytes.Valid {
+		status.SizeBytes = export.SizeBytes.Int64
+	}
+	dst, err := json.Marshal(status)
+	if err != nil {
+		log.Printf("Error marshalling %v: %s", status, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(code)
+	w.Write(dst)
+}
+
+// buildExport writes the archive for export into the export store and
+// marks the row ready, or failed if anything goes wrong.
+func (cfg *apiConfig) buildExport(export database.DataExport) {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	key := export.ID.String() + ".zip"
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(cfg.writeExportArchive(ctx, pw, export.UserID))
+	}()
+	counter := &countingReader{r: pr}
+	err := cfg.exports.Put(ctx, key, counter)
+	pr.CloseWithError(err)
+	if err == nil {
+		err = cfg.db.CompleteDataExport(ctx, database.CompleteDataExportParams{
+			ID: export.ID,
+			StorageKey: sql.NullString{String: key, Valid: true},
+			SizeBytes: sql.NullInt64{Int64: counter.n, Valid: true},
+			ExpiresAt: sql.NullTime{Time: time.Now().UTC().Add(exportTTL), Valid: true},
+		})
+	}
+	if err != nil {
+		log.Printf("Error building export %v: %s", export.ID, err)
+		cfg.exports.Delete(context.Background(), key)
+		err = cfg.db.FailDataExport(context.Background(), export.ID)
+		if err != nil {
+			log.Printf("Error marking export %v failed: %s", export.ID, err)
+		}
+	}
+}
+
+// writeExportArchive writes a zip with one JSON file per kind of data and
+// the original of every uploaded image under media/.
+func (cfg *apiConfig) writeExportArchive(ctx context.Context, w io.Writer, userID uuid.UUID) error {
+	archive := zip.NewWriter(w)
+
+	user, err := cfg.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	profile := exportProfile{
+		ID: user.ID,
+		Email: user.Email,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+		DisplayName: user.DisplayName,
+		Bio: user.Bio,
+		Website: user.Website,
+		IsChirpyRd: user.IsChirpyRed,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+	}
+	if user.Handle.Valid {
+		profile.Handle = user.Handle.String
+	}
+	if user.AvatarMediaID.Valid {
+		profile.AvatarMediaID = &user.AvatarMediaID.UUID
+	}
+	err = writeExportJSON(archive, "profile.json", profile)
+	if err != nil {
+		return err
+	}
+
+	chirps, err := cfg.db.GetChirpsByAuthor(ctx, userID)
+	if err != nil {
+		return err
+	}
+	exportChirps := make([]exportChirp, 0, len(chirps))
+	for _, val := range chirps {
+		chirp := exportChirp{ID: val.ID, CreatedAt: val.CreatedAt, UpdatedAt: val.UpdatedAt, Body: val.Body}
+		if val.InReplyTo.Valid {
+			chirp.InReplyTo = &val.InReplyTo.UUID
+		}
+		if val.QuotedChirpID.Valid {
+			chirp.QuotedChirpID = &val.QuotedChirpID.UUID
+		}
+		exportChirps = append(exportChirps, chirp)
+	}
+	err = writeExportJSON(archive, "chirps.json", exportChirps)
+	if err != nil {
+		return err
+	}
+
+	// the token values themselves are credentials and stay out of the archive
+	tokens, err := cfg.db.GetUserRefreshTokens(ctx, userID)
+	if err != nil {
+		return err
+	}
+	sessions := make([]exportSession, 0, len(tokens))
+	for _, val := range tokens {
+		session := exportSession{
+			SessionID: val.FamilyID,
+			UserAgent: val.UserAgent,
+			IPAddress: val.IpAddress,
+			CreatedAt: val.CreatedAt,
+			UpdatedAt: val.UpdatedAt,
+			LastUsedAt: val.LastUsedAt,
+			ExpiresAt: val.ExpiresAt,
+		}
+		if val.RevokedAt.Valid {
+			session.RevokedAt = &val.RevokedAt.Time
+		}
+		sessions = append(sessions, session)
+	}
+	err = writeExportJSON(archive, "sessions.json", sessions)
+	if err != nil {
+		return err
+	}
+
+	events, err := cfg.db.GetChirpyRedEvents(ctx, userID)
+	if err != nil {
+		return err
+	}
+	red := exportChirpyRed{IsChirpyRd: user.IsChirpyRed, History: make([]exportChirpyRedEvent, 0, len(events))}
+	for _, val := range events {
+		red.History = append(red.History, exportChirpyRedEvent{Event: val.Event, IsChirpyRd: val.IsChirpyRed, CreatedAt: val.CreatedAt})
+	}
+	err = writeExportJSON(archive, "chirpy_red.json", red)
+	if err != nil {
+		return err
+	}
+
+	media, err := cfg.db.GetUserMedia(ctx, userID)
+	if err != nil {
+		return err
+	}
+	exportMedia := make([]exportMediaFile, 0, len(media))
+	for _, val := range media {
+		file := exportMediaFile{
+			ID: val.ID,
+			File: "media/" + val.StorageKey,
+			ContentType: val.ContentType,
+			Width: int(val.Width),
+			Height: int(val.Height),
+			CreatedAt: val.CreatedAt,
+		}
+		if val.ChirpID.Valid {
+			file.ChirpID = &val.ChirpID.UUID
+		}
+		err = cfg.copyExportMedia(ctx, archive, file.File, val.StorageKey)
+		if err != nil {
+			return err
+		}
+		exportMedia = append(exportMedia, file)
+	}
+	err = writeExportJSON(archive, "media.json", exportMedia)
+	if err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+func writeExportJSON(archive *zip.Writer, name string, v interface{}) error {
+	f, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func (cfg *apiConfig) copyExportMedia(ctx context.Context, archive *zip.Writer, name string, key string) error {
+	src, err := cfg.media.Open(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	// images are already compressed
+	dst, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// failStaleExports marks exports failed that have been pending for longer
+// than buildExport may run. Their goroutine is gone, usually because the
+// server restarted while building them.
+func (cfg *apiConfig) failStaleExports(ctx context.Context) error {
+	failed, err := cfg.db.FailStaleDataExports(ctx, int64(exportTimeout.Seconds()))
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		log.Printf("Marked %d stale exports failed", failed)
+	}
+	return nil
+}
+
+// purgeExpiredExports deletes archives past their expiry date.
+func (cfg *apiConfig) purgeExpiredExports(ctx context.Context) error {
+	exports, err := cfg.db.GetExpiredDataExports(ctx)
+	if err != nil {
+		return err
+	}
+	for _, val := range exports {
+		if val.StorageKey.Valid {
+			err = cfg.exports.Delete(ctx, val.StorageKey.String)
+			if err != nil {
+				log.Printf("Error deleting export %v: %s", val.ID, err)
+				continue
+			}
+		}
+		err = cfg.db.DeleteDataExport(ctx, val.ID)
+		if err != nil {
+			log.Printf("Error deleting export %v: %s", val.ID, err)
+		}
+	}
+	return nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/xsynch/chirpy/internal/database"
+)
+
+func (cfg *apiConfig) followUser(w http.ResponseWriter, r *http.Request){
+	userID := authUserID(r)
+	followeeID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid user id"))
+		return
+	}
+	if followeeID == userID {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Users cannot follow themselves"))
+		return
+	}
+	_, err = cfg.db.GetUserByID(r.Context(), followeeID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("User not found"))
+			return
+		}
+		log.Printf("Error looking up user %v: %s", followeeID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	params := database.FollowUserParams{FollowerID: userID, FolloweeID: followeeID}
+	err = cfg.db.FollowUser(r.Context(), params)
+	if err != nil {
+		log.Printf("Error following %v for user %v: %s", followeeID, userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) unfollowUser(w http.ResponseWriter, r *http.Request){
+	userID := authUserID(r)
+	followeeID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid user id"))
+		return
+	}
+	params := database.UnfollowUserParams{FollowerID: userID, FolloweeID: followeeID}
+	err = cfg.db.UnfollowUser(r.Context(), params)
+	if err != nil {
+		log.Printf("Error unfollowing %v for user %v: %s", followeeID, userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getFollowers and getFollowing page newest follow first, the cursor is
+// the created_at and user id of the last entry.
+func (cfg *apiConfig) getFollowers(w http.ResponseWriter, r *http.Request){
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid user id"))
+		return
+	}
+	page, err := parsePageRequest(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	params := database.GetFollowersParams{FolloweeID: userID, PageSize: int32(page.Limit + 1)}
+	params.CursorCreatedAt, params.CursorID = page.cursorArgs()
+	results, err := cfg.db.GetFollowers(r.Context(), params)
+	if err != nil {
+		log.Printf("Error getting followers of %v: %s", userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error getting followers"))
+		return
+	}
+	followers := []followEntry{}
+	for _, val := range results {
+		followers = append(followers, followEntry{UserID: val.UserID, CreatedAt: val.CreatedAt})
+	}
+	writeFollowPage(w, followers, page.Limit)
+}
+
+func (cfg *apiConfig) getFollowing(w http.ResponseWriter, r *http.Request){
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid user id"))
+		return
+	}
+	page, err := parsePageRequest(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	params := database.GetFollowingParams{FollowerID: userID, PageSize: int32(page.Limit + 1)}
+	params.CursorCreatedAt, params.CursorID = page.cursorArgs()
+	results, err := cfg.db.GetFollowing(r.Context(), params)
+	if err != nil {
+		log.Printf("Error getting accounts followed by %v: %s", userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error getting following"))
+		return
+	}
+	following := []followEntry{}
+	for _, val := range results {
+		following = append(following, followEntry{UserID: val.UserID, CreatedAt: val.CreatedAt})
+	}
+	writeFollowPage(w, following, page.Limit)
+}
+
+// writeFollowPage writes up to limit entries, which were fetched with one
+// extra to find out whether there is another page.
+func writeFollowPage(w http.ResponseWriter, entries []followEntry, limit int){
+	followPage := followPage{Users: entries}
+	if len(entries) > limit {
+		followPage.Users = entries[:limit]
+		last := followPage.Users[limit-1]
+		followPage.NextCursor = encodeCursor(chirpCursor{CreatedAt: last.CreatedAt, ID: last.UserID})
+	}
+	dst, err := json.Marshal(followPage)
+	if err != nil {
+		log.Printf("Error marshalling %v: %s", followPage, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("There was an error marshalling the final request"))
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dst)
+}
+
+// getTimeline returns chirps from the accounts the caller follows, newest first.
+func (cfg *apiConfig) getTimeline(w http.ResponseWriter, r *http.Request){
+	userID := authUserID(r)
+	page, err := parsePageRequest(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	params := database.GetTimelineParams{FollowerID: userID, PageSize: int32(page.Limit + 1)}
+	params.CursorCreatedAt, params.CursorID = page.cursorArgs()
+	results, err := cfg.db.GetTimeline(r.Context(), params)
+	if err != nil {
+		log.Printf("Error getting timeline for %v: %s", userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error getting timeline"))
+		return
+	}
+	rechirps, err := cfg.db.GetTimelineRechirps(r.Context(), database.GetTimelineRechirpsParams(params))
+	if err != nil {
+		log.Printf("Error getting timeline rechirps for %v: %s", userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error getting timeline"))
+		return
+	}
+	items := chirpFeedItems(chirpsFromRows(results))
+	for _, val := range rechirps {
+		items = append(items, rechirpFeedItem(val.Rechirp, chirpFromColumns(chirpColumns{val.ID, val.CreatedAt, val.UpdatedAt, val.Body, val.UserID, val.InReplyTo, val.QuotedChirpID})))
+	}
+	sortFeed(items, true)
+
+	timeline := chirpPage{}
+	if len(items) > page.Limit {
+		items = items[:page.Limit]
+		timeline.NextCursor = encodeCursor(items[len(items)-1].cursor())
+	}
+	timeline.Chirps, err = cfg.buildFeedResponses(r.Context(), items, userID)
+	if err != nil {
+		log.Printf("Error building timeline for %v: %s", userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error getting timeline"))
+		return
+	}
+	dst, err := json.Marshal(timeline)
+	if err != nil {
+		log.Printf("Error marshalling %v: %s", timeline, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error getting timeline"))
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dst)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"log"
 	"net/http"
@@ -11,21 +12,55 @@ import (
 	_ "github.com/lib/pq"
 
 	"github.com/xsynch/chirpy/internal/auth"
+	"github.com/xsynch/chirpy/internal/blobstore"
 	"github.com/xsynch/chirpy/internal/database"
+	"github.com/xsynch/chirpy/internal/filter"
+	"github.com/xsynch/chirpy/internal/jwtkeys"
+	"github.com/xsynch/chirpy/internal/mailer"
+	"github.com/xsynch/chirpy/internal/oidc"
 )
 
 
 type apiConfig struct {
 	fileserverHits atomic.Int32
 	db *database.Queries
+	// sqlDB starts transactions, queries run in them through db.WithTx
+	sqlDB *sql.DB
+	// secret signed HS256 tokens before signing keys, they are only still
+	// accepted with JWT_ACCEPT_LEGACY_HS256
 	secret string 
+	signingKeys *jwtkeys.Set
+	signing_alg string
+	key_rotation time.Duration
+	lastKeyReload atomic.Int64
 	polka_key string 
+	admin_key string
+	// filterRules come from FILTER_WORDS and are always applied, the admin
+	// API layers the words stored in filter_words on top of them
+	filterRules []filter.Rule
+	filterWords *filter.WordList
+	contentFilter filter.Pipeline
+	media blobstore.Store
+	mediaPath string
+	// exports is private, unlike media it is not under the /app/ file server
+	exports blobstore.Store
+	mailer mailer.Mailer
+	public_url string
+	require_verified_email bool
+	deletion_grace time.Duration
+	// now is the clock for TOTP codes and login challenges
+	now func() time.Time
+	// oidcProviders are the external login providers by name
+	oidcProviders map[string]*oidc.Provider
 
 }
 
 type incomingChirp struct {
 	Body string `json:"body"`
 	UserID uuid.UUID `json:"user_id"`
+	InReplyTo *uuid.UUID `json:"in_reply_to,omitempty"`
+	QuotedChirpID *uuid.UUID `json:"quoted_chirp_id,omitempty"`
+	MediaIDs []uuid.UUID `json:"media_ids,omitempty"`
 }
 type chirpError struct {
 	Error string `json:"error"`
@@ -36,7 +71,18 @@ type chirpSuccess struct{
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	Body string 		`json:"body"`
-	UserID uuid.UUID	`json:"user_id"`
+	Withheld bool `json:"withheld,omitempty"`
+	Author chirpAuthor `json:"author"`
+	InReplyTo *uuid.UUID `json:"in_reply_to,omitempty"`
+	ReplyCount int64 `json:"reply_count"`
+	LikeCount int64 `json:"like_count"`
+	LikedByMe bool `json:"liked_by_me"`
+	QuotedChirpID *uuid.UUID `json:"quoted_chirp_id,omitempty"`
+	QuotedChirp *chirpSuccess `json:"quoted_chirp,omitempty"`
+	RechirpedBy *uuid.UUID `json:"rechirped_by,omitempty"`
+	RechirpedAt *time.Time `json:"rechirped_at,omitempty"`
+	Entities []chirpEntity `json:"entities"`
+	Media []chirpMedia `json:"media"`
 }
 
 type chirpUser struct {
@@ -53,10 +99,249 @@ type createDBUserResponse struct {
 	Token string `json:"token,omitempty"`
 	RefreshToken string `json:"refresh_token,omitempty"`
 	IsChirpyRd bool `json:"is_chirpy_red"`
+	EmailVerified bool `json:"email_verified"`
+	PendingEmail string `json:"pending_email,omitempty"`
+}
+
+type accountDeletion struct {
+	DeletionRequestedAt time.Time `json:"deletion_requested_at"`
+	PurgeAfter time.Time `json:"purge_after"`
+}
+
+type dataExport struct {
+	ID uuid.UUID `json:"id"`
+	Status string `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	SizeBytes int64 `json:"size_bytes,omitempty"`
+}
+
+type exportProfile struct {
+	ID uuid.UUID `json:"id"`
+	Email string `json:"email"`
+	EmailVerified bool `json:"email_verified"`
+	Handle string `json:"handle,omitempty"`
+	DisplayName string `json:"display_name"`
+	Bio string `json:"bio"`
+	Website string `json:"website"`
+	AvatarMediaID *uuid.UUID `json:"avatar_media_id,omitempty"`
+	IsChirpyRd bool `json:"is_chirpy_red"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type exportChirp struct {
+	ID uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Body string `json:"body"`
+	InReplyTo *uuid.UUID `json:"in_reply_to,omitempty"`
+	QuotedChirpID *uuid.UUID `json:"quoted_chirp_id,omitempty"`
+}
+
+type session struct {
+	ID uuid.UUID `json:"id"`
+	UserAgent string `json:"user_agent"`
+	IPAddress string `json:"ip_address"`
+	CreatedAt time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type exportSession struct {
+	SessionID uuid.UUID `json:"session_id"`
+	UserAgent string `json:"user_agent"`
+	IPAddress string `json:"ip_address"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+type exportChirpyRed struct {
+	IsChirpyRd bool `json:"is_chirpy_red"`
+	History []exportChirpyRedEvent `json:"history"`
+}
+
+type exportChirpyRedEvent struct {
+	Event string `json:"event"`
+	IsChirpyRd bool `json:"is_chirpy_red"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type exportMediaFile struct {
+	ID uuid.UUID `json:"id"`
+	File string `json:"file"`
+	ContentType string `json:"content_type"`
+	Width int `json:"width"`
+	Height int `json:"height"`
+	ChirpID *uuid.UUID `json:"chirp_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type twoFactorEnrollment struct {
+	OtpauthURI string `json:"otpauth_uri"`
+	Secret string `json:"secret"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type loginChallenge struct {
+	TwoFactorRequired bool `json:"two_factor_required"`
+	ChallengeToken string `json:"challenge_token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type personalAccessToken struct {
+	ID uuid.UUID `json:"id"`
+	Name string `json:"name"`
+	Scopes []string `json:"scopes"`
+	Token string `json:"token,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type oauthClient struct {
+	ClientID uuid.UUID `json:"client_id"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	Name string `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Confidential bool `json:"confidential"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type oauthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType string `json:"token_type"`
+	ExpiresIn int `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope string `json:"scope"`
+}
+
+type oauthIntrospection struct {
+	Active bool `json:"active"`
+	Scope string `json:"scope,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	Subject string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	IssuedAt int64 `json:"iat,omitempty"`
+	ExpiresAt int64 `json:"exp,omitempty"`
+}
+
+type userUpdate struct {
+	Email string `json:"email"`
+	Password string `json:"password"`
+	CurrentPassword string `json:"current_password"`
+}
+
+// chirpEntity offsets are in runes, end exclusive.
+type chirpEntity struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+	Start int `json:"start"`
+	End int `json:"end"`
+	UserID *uuid.UUID `json:"user_id,omitempty"`
+}
+
+type filterWord struct {
+	Word string `json:"word"`
+	Action string `json:"action"`
+	Source string `json:"source,omitempty"`
+}
+
+type chirpReview struct {
+	ChirpID uuid.UUID `json:"chirp_id"`
+	UserID uuid.UUID `json:"user_id"`
+	Body string `json:"body"`
+	MatchedWords []string `json:"matched_words"`
+	FlaggedAt time.Time `json:"flagged_at"`
+}
+
+type chirpMedia struct {
+	ID uuid.UUID `json:"id"`
+	URL string `json:"url"`
+	ContentType string `json:"content_type"`
+	Width int `json:"width"`
+	Height int `json:"height"`
+	Variants map[string]mediaVariant `json:"variants"`
+}
+
+type mediaVariant struct {
+	URL string `json:"url"`
+	ContentType string `json:"content_type"`
+	Width int `json:"width"`
+	Height int `json:"height"`
+}
+
+type chirpPage struct {
+	Chirps []chirpSuccess `json:"chirps"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type followEntry struct {
+	UserID uuid.UUID `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type followPage struct {
+	Users []followEntry `json:"users"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type threadNode struct {
+	chirpSuccess
+	Replies []*threadNode `json:"replies"`
+}
+
+type chirpThread struct {
+	Ancestors []chirpSuccess `json:"ancestors"`
+	Chirp *threadNode `json:"chirp"`
+	// Truncated is set when replies past maxThreadDepth or maxThreadReplies
+	// were left out
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+type chirpRevision struct {
+	ID uuid.UUID `json:"id"`
+	ChirpID uuid.UUID `json:"chirp_id"`
+	Body string `json:"body"`
+	Withheld bool `json:"withheld,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+// chirpAuthor is the compact user object embedded in chirps.
+type chirpAuthor struct {
+	ID uuid.UUID `json:"id"`
+	Handle string `json:"handle,omitempty"`
+	DisplayName string `json:"display_name"`
+	AvatarURL string `json:"avatar_url,omitempty"`
+}
+
+type publicProfile struct {
+	ID uuid.UUID `json:"id"`
+	Handle string `json:"handle,omitempty"`
+	DisplayName string `json:"display_name"`
+	Bio string `json:"bio"`
+	AvatarURL string `json:"avatar_url,omitempty"`
+	Website string `json:"website,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	IsChirpyRd bool `json:"is_chirpy_red"`
+}
+
+type profileUpdate struct {
+	Handle *string `json:"handle"`
+	DisplayName *string `json:"display_name"`
+	Bio *string `json:"bio"`
+	AvatarMediaID *uuid.UUID `json:"avatar_media_id"`
+	Website *string `json:"website"`
 }
 
 type NewJWT struct {
 	Token string `json:"token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
 }
 
 type chirpyRedUpdate struct {
@@ -108,6 +393,11 @@ func (cfg *apiConfig) upgradeChirpyUser (w http.ResponseWriter, r *http.Request)
 			w.WriteHeader(http.StatusNotFound)			
 			return 
 		}
+		// history for data exports, the upgrade itself already succeeded
+		err = cfg.db.InsertChirpyRedEvent(r.Context(), database.InsertChirpyRedEventParams{UserID: chirpyEvent.Data.UserID, Event: chirpyEvent.Event, IsChirpyRed: true})
+		if err != nil {
+			log.Printf("Error recording Chirpy Red event for %v: %s", chirpyEvent.Data.UserID, err)
+		}
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
\ No newline at end of file
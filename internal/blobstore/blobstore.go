@@ -0,0 +1,21 @@
+// Package blobstore stores uploaded files behind a small interface so the
+// backend can be swapped without touching the handlers.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrNotFound is returned when a key does not exist in the store.
+var ErrNotFound = errors.New("blob not found")
+
+// Store saves blobs under caller chosen keys and knows the public URL each
+// one is served from.
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	URL(key string) string
+}
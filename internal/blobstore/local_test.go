@@ -0,0 +1,90 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalKeyValidation(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLocal(filepath.Join(dir, "media"), "/app/media/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{key: "4b1c0e4e-5d0c-4d2b-9d6a-0f7a3c1b2e11.jpg", valid: true},
+		{key: "4b1c0e4e_thumb.png", valid: true},
+		{key: "", valid: false},
+		{key: ".", valid: false},
+		{key: "..", valid: false},
+		{key: "../escape.jpg", valid: false},
+		{key: "nested/key.jpg", valid: false},
+		{key: "/etc/passwd", valid: false},
+		{key: ".hidden", valid: false},
+		{key: ".upload-123", valid: false},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			err := l.Put(ctx, tt.key, strings.NewReader("data"))
+			if (err == nil) != tt.valid {
+				t.Errorf("Put(%q) error = %v, want valid %v", tt.key, err, tt.valid)
+			}
+			_, err = l.Open(ctx, tt.key)
+			if !tt.valid && err == nil {
+				t.Errorf("Open(%q) succeeded for an invalid key", tt.key)
+			}
+			err = l.Delete(ctx, tt.key)
+			if (err == nil) != tt.valid {
+				t.Errorf("Delete(%q) error = %v, want valid %v", tt.key, err, tt.valid)
+			}
+		})
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escape.jpg")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("a key escaped the store directory")
+	}
+}
+
+func TestLocalRoundTrip(t *testing.T) {
+	l, err := NewLocal(t.TempDir(), "/app/media/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	err = l.Put(ctx, "a.txt", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := l.Open(ctx, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("Open read %q, %v, want %q", data, err, "hello")
+	}
+	if got, want := l.URL("a.txt"), "/app/media/a.txt"; got != want {
+		t.Errorf("URL = %q, want %q", got, want)
+	}
+	err = l.Delete(ctx, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = l.Open(ctx, "a.txt")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open after Delete error = %v, want ErrNotFound", err)
+	}
+	err = l.Delete(ctx, "a.txt")
+	if err != nil {
+		t.Errorf("Delete of a missing key error = %v, want nil", err)
+	}
+}
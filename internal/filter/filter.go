@@ -0,0 +1,209 @@
+// Package filter checks chirp bodies against a configurable list of words.
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Action is what happens to a chirp that contains a listed word.
+type Action string
+
+const (
+	// Mask replaces the word with asterisks.
+	Mask Action = "mask"
+	// Reject refuses the chirp outright.
+	Reject Action = "reject"
+	// Flag keeps the chirp as written but queues it for moderator review.
+	Flag Action = "flag"
+)
+
+const maskText = "****"
+
+// ParseAction validates an action name from config or the admin API.
+func ParseAction(s string) (Action, error) {
+	switch Action(strings.ToLower(strings.TrimSpace(s))) {
+	case Mask:
+		return Mask, nil
+	case Reject:
+		return Reject, nil
+	case Flag:
+		return Flag, nil
+	}
+	return "", fmt.Errorf("unknown filter action: %q", s)
+}
+
+// Rule pairs a listed word with the action taken when it is found.
+type Rule struct {
+	Word   string
+	Action Action
+}
+
+// Result is the outcome of running a body through a Filter.
+type Result struct {
+	Body     string
+	Rejected bool
+	Flagged  bool
+	Matches  []string
+}
+
+// Filter is one stage of a Pipeline. A stage sees the body as left by the
+// stages before it and may rewrite it or mark it rejected or flagged.
+type Filter interface {
+	Apply(result *Result)
+}
+
+// Pipeline runs its stages in order and stops early once a body is rejected.
+type Pipeline []Filter
+
+func (p Pipeline) Check(body string) Result {
+	result := Result{Body: body}
+	for _, stage := range p {
+		stage.Apply(&result)
+		if result.Rejected {
+			break
+		}
+	}
+	return result
+}
+
+// WordList matches whole words regardless of case, surrounding punctuation,
+// accents and full-width forms. It is safe to replace the rules while other
+// goroutines are filtering.
+type WordList struct {
+	mu    sync.RWMutex
+	rules map[string]Action
+}
+
+func NewWordList(rules []Rule) *WordList {
+	w := &WordList{}
+	w.Replace(rules)
+	return w
+}
+
+// Replace swaps in a new set of rules.
+func (w *WordList) Replace(rules []Rule) {
+	normalised := make(map[string]Action, len(rules))
+	for _, rule := range rules {
+		normalised[Normalize(rule.Word)] = rule.Action
+	}
+	w.mu.Lock()
+	w.rules = normalised
+	w.mu.Unlock()
+}
+
+func (w *WordList) Apply(result *Result) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if len(w.rules) == 0 {
+		return
+	}
+
+	var out strings.Builder
+	rest := result.Body
+	for len(rest) > 0 {
+		start := strings.IndexFunc(rest, isWordRune)
+		if start < 0 {
+			out.WriteString(rest)
+			break
+		}
+		out.WriteString(rest[:start])
+		rest = rest[start:]
+		end := strings.IndexFunc(rest, func(r rune) bool { return !isWordRune(r) })
+		if end < 0 {
+			end = len(rest)
+		}
+		word := rest[:end]
+		rest = rest[end:]
+
+		action, ok := w.rules[Normalize(word)]
+		if !ok {
+			out.WriteString(word)
+			continue
+		}
+		result.Matches = append(result.Matches, word)
+		switch action {
+		case Mask:
+			out.WriteString(maskText)
+		case Reject:
+			result.Rejected = true
+			out.WriteString(word)
+		case Flag:
+			result.Flagged = true
+			out.WriteString(word)
+		}
+	}
+	result.Body = out.String()
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.Is(unicode.Mn, r)
+}
+
+// Normalize folds a word to the form used for matching: lower case, with
+// full-width letters mapped to ASCII and accents and combining marks removed.
+func Normalize(word string) string {
+	var b strings.Builder
+	for _, r := range word {
+		// full-width forms of the printable ASCII range
+		if r >= 0xFF01 && r <= 0xFF5E {
+			r -= 0xFEE0
+		}
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		r = unicode.ToLower(r)
+		if base, ok := accentFold[r]; ok {
+			b.WriteString(base)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// accentFold maps precomposed Latin letters to their unaccented base.
+var accentFold = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'æ': "ae",
+	'ç': "c", 'ć': "c", 'č': "c",
+	'ď': "d", 'đ': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+	'ğ': "g",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'į': "i", 'ı': "i",
+	'ł': "l", 'ľ': "l",
+	'ñ': "n", 'ń': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ő': "o",
+	'œ': "oe",
+	'ř': "r",
+	'ś': "s", 'š': "s", 'ş': "s", 'ß': "ss",
+	'ť': "t", 'ţ': "t",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u", 'ű': "u",
+	'ý': "y", 'ÿ': "y",
+	'ź': "z", 'ż': "z", 'ž': "z",
+}
+
+// ParseRules reads a comma separated list of word:action pairs, e.g.
+// "kerfuffle:mask,fornax:flag". A word without an action is masked.
+func ParseRules(s string) ([]Rule, error) {
+	rules := []Rule{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		word, action, found := strings.Cut(entry, ":")
+		rule := Rule{Word: strings.TrimSpace(word), Action: Mask}
+		if found {
+			parsed, err := ParseAction(action)
+			if err != nil {
+				return nil, err
+			}
+			rule.Action = parsed
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
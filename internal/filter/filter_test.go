@@ -0,0 +1,142 @@
+package filter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWordList(t *testing.T) {
+	list := NewWordList([]Rule{
+		{Word: "kerfuffle", Action: Mask},
+		{Word: "café", Action: Mask},
+		{Word: "fornax", Action: Flag},
+		{Word: "sharbert", Action: Reject},
+	})
+	tests := []struct {
+		name string
+		body string
+		want Result
+	}{
+		{
+			name: "clean body",
+			body: "nothing to see here",
+			want: Result{Body: "nothing to see here"},
+		},
+		{
+			name: "mask keeps punctuation",
+			body: "what a kerfuffle!",
+			want: Result{Body: "what a ****!", Matches: []string{"kerfuffle"}},
+		},
+		{
+			name: "case insensitive",
+			body: "KerFuffle time",
+			want: Result{Body: "**** time", Matches: []string{"KerFuffle"}},
+		},
+		{
+			name: "full-width letters",
+			body: "ｋｅｒｆｕｆｆｌｅ",
+			want: Result{Body: "****", Matches: []string{"ｋｅｒｆｕｆｆｌｅ"}},
+		},
+		{
+			name: "accents are folded",
+			body: "kérfüffle",
+			want: Result{Body: "****", Matches: []string{"kérfüffle"}},
+		},
+		{
+			name: "combining marks are ignored",
+			body: "cafe\u0301 au lait",
+			want: Result{Body: "**** au lait", Matches: []string{"cafe\u0301"}},
+		},
+		{
+			name: "rule word with accent matches plain spelling",
+			body: "cafe au lait",
+			want: Result{Body: "**** au lait", Matches: []string{"cafe"}},
+		},
+		{
+			name: "whole words only",
+			body: "kerfuffles and fornaxes",
+			want: Result{Body: "kerfuffles and fornaxes"},
+		},
+		{
+			name: "flag keeps the word",
+			body: "Fornax rising",
+			want: Result{Body: "Fornax rising", Flagged: true, Matches: []string{"Fornax"}},
+		},
+		{
+			name: "reject keeps the word",
+			body: "sharbert.",
+			want: Result{Body: "sharbert.", Rejected: true, Matches: []string{"sharbert"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Pipeline{list}.Check(tt.body)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check(%q) = %+v, want %+v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWordListReplace(t *testing.T) {
+	list := NewWordList([]Rule{{Word: "kerfuffle", Action: Mask}})
+	list.Replace([]Rule{{Word: "fornax", Action: Mask}})
+	got := Pipeline{list}.Check("kerfuffle fornax")
+	if got.Body != "kerfuffle ****" {
+		t.Errorf("Check after Replace = %q, want %q", got.Body, "kerfuffle ****")
+	}
+}
+
+type countStage struct{ calls *int }
+
+func (c countStage) Apply(result *Result) { *c.calls++ }
+
+func TestPipelineStopsAfterReject(t *testing.T) {
+	calls := 0
+	p := Pipeline{
+		NewWordList([]Rule{{Word: "sharbert", Action: Reject}}),
+		countStage{&calls},
+	}
+	if got := p.Check("sharbert"); !got.Rejected {
+		t.Fatalf("Check(%q).Rejected = false, want true", "sharbert")
+	}
+	if calls != 0 {
+		t.Errorf("stage after a rejection ran %d times, want 0", calls)
+	}
+	p.Check("fine")
+	if calls != 1 {
+		t.Errorf("stage ran %d times for a clean body, want 1", calls)
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    []Rule
+		wantErr bool
+	}{
+		{name: "empty", in: "", want: []Rule{}},
+		{
+			name: "default action is mask",
+			in:   "kerfuffle, fornax:FLAG ,sharbert:reject,",
+			want: []Rule{
+				{Word: "kerfuffle", Action: Mask},
+				{Word: "fornax", Action: Flag},
+				{Word: "sharbert", Action: Reject},
+			},
+		},
+		{name: "unknown action", in: "kerfuffle:delete", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRules(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRules(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseRules(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
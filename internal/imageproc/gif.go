@@ -0,0 +1,73 @@
+package imageproc
+
+import (
+	"errors"
+	"image"
+)
+
+// MaxGIFPixels bounds frames times logical screen pixels of an animation.
+// gif.DecodeAll keeps every frame in memory, so a GIF that passes
+// CheckDimensions can still be a bomb made of many frames.
+const MaxGIFPixels = 2 * MaxPixels
+
+// MaxGIFFrames bounds the frame count on its own, each frame carries its
+// own allocation however small it is.
+const MaxGIFFrames = 1000
+
+var errMalformedGIF = errors.New("malformed gif")
+
+// checkGIFFrames walks the blocks of a GIF, skipping the image data, and
+// rejects animations whose frames would not fit in the pixel budget.
+func checkGIFFrames(data []byte, screen image.Config) error {
+	// header and logical screen descriptor
+	if len(data) < 13 {
+		return errMalformedGIF
+	}
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+	budget := MaxGIFPixels / (screen.Width * screen.Height)
+	frames := 0
+	for {
+		if i >= len(data) {
+			return errMalformedGIF
+		}
+		switch data[i] {
+		case 0x21:
+			// extension: label, then sub-blocks
+			i += 2
+		case 0x2C:
+			frames++
+			if frames > MaxGIFFrames || frames > budget {
+				return ErrTooLarge
+			}
+			// image descriptor, optional local colour table, LZW code
+			// size, then sub-blocks
+			if i+10 > len(data) {
+				return errMalformedGIF
+			}
+			packed := data[i+9]
+			i += 10
+			if packed&0x80 != 0 {
+				i += 3 << (packed&0x07 + 1)
+			}
+			i++
+		case 0x3B:
+			return nil
+		default:
+			return errMalformedGIF
+		}
+		for {
+			if i >= len(data) {
+				return errMalformedGIF
+			}
+			n := int(data[i])
+			i++
+			if n == 0 {
+				break
+			}
+			i += n
+		}
+	}
+}
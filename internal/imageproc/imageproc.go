@@ -0,0 +1,189 @@
+// Package imageproc prepares uploaded images for serving: it strips metadata
+// by re-encoding, applies EXIF orientation, and renders smaller variants.
+// Everything is done with the standard library image packages.
+package imageproc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+// MaxPixels bounds width*height of images we are willing to decode. A small
+// compressed file can describe a huge canvas, so the header is checked
+// before any pixel data is decoded.
+const MaxPixels = 24_000_000
+
+// MaxDimension bounds either side of an image.
+const MaxDimension = 12_000
+
+const jpegQuality = 85
+
+// ErrTooLarge is returned for images whose decoded size exceeds the limits.
+var ErrTooLarge = errors.New("image dimensions are too large")
+
+// ErrUnsupported is returned for content types Process does not handle.
+var ErrUnsupported = errors.New("unsupported image type")
+
+// VariantSpec names a variant and the box it is scaled down to fit.
+type VariantSpec struct {
+	Name    string
+	MaxSide int
+}
+
+// DefaultVariants are rendered for every upload.
+var DefaultVariants = []VariantSpec{
+	{Name: "thumb", MaxSide: 200},
+	{Name: "preview", MaxSide: 800},
+}
+
+// Image is an encoded image with its pixel size.
+type Image struct {
+	Data        []byte
+	ContentType string
+	Ext         string
+	Width       int
+	Height      int
+}
+
+// Result holds the cleaned original and the rendered variants, keyed by
+// VariantSpec.Name.
+type Result struct {
+	Original Image
+	Variants map[string]Image
+}
+
+// CheckDimensions reads only the image header and rejects decompression bombs.
+func CheckDimensions(data []byte) (image.Config, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return image.Config{}, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return image.Config{}, fmt.Errorf("invalid image size %dx%d", cfg.Width, cfg.Height)
+	}
+	if cfg.Width > MaxDimension || cfg.Height > MaxDimension || cfg.Width*cfg.Height > MaxPixels {
+		return image.Config{}, ErrTooLarge
+	}
+	return cfg, nil
+}
+
+// Process decodes an upload, drops all metadata and renders the variants.
+// JPEG and PNG originals keep their format; GIFs keep their frames and the
+// variants are rendered from the first frame as PNG.
+func Process(data []byte, contentType string, variants []VariantSpec) (Result, error) {
+	cfg, err := CheckDimensions(data)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var src *image.NRGBA
+	var original Image
+	switch contentType {
+	case "image/jpeg":
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return Result{}, err
+		}
+		src = applyOrientation(toNRGBA(img), jpegOrientation(data))
+		original, err = encodeJPEG(src)
+		if err != nil {
+			return Result{}, err
+		}
+	case "image/png":
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return Result{}, err
+		}
+		src = toNRGBA(img)
+		original, err = encodePNG(src)
+		if err != nil {
+			return Result{}, err
+		}
+	case "image/gif":
+		err := checkGIFFrames(data, cfg)
+		if err != nil {
+			return Result{}, err
+		}
+		anim, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return Result{}, err
+		}
+		if len(anim.Image) == 0 {
+			return Result{}, errors.New("gif has no frames")
+		}
+		// EncodeAll only writes frames, palette and loop count, so comment
+		// and application extensions are dropped
+		var buf bytes.Buffer
+		err = gif.EncodeAll(&buf, &gif.GIF{
+			Image:     anim.Image,
+			Delay:     anim.Delay,
+			LoopCount: anim.LoopCount,
+			Disposal:  anim.Disposal,
+			Config:    anim.Config,
+		})
+		if err != nil {
+			return Result{}, err
+		}
+		src = toNRGBA(anim.Image[0])
+		original = Image{
+			Data:        buf.Bytes(),
+			ContentType: "image/gif",
+			Ext:         ".gif",
+			Width:       anim.Config.Width,
+			Height:      anim.Config.Height,
+		}
+	default:
+		return Result{}, ErrUnsupported
+	}
+
+	result := Result{Original: original, Variants: map[string]Image{}}
+	for _, spec := range variants {
+		w, h := fitWithin(src.Bounds().Dx(), src.Bounds().Dy(), spec.MaxSide)
+		scaled := resize(src, w, h)
+		var variant Image
+		if contentType == "image/jpeg" {
+			variant, err = encodeJPEG(scaled)
+		} else {
+			variant, err = encodePNG(scaled)
+		}
+		if err != nil {
+			return Result{}, err
+		}
+		result.Variants[spec.Name] = variant
+	}
+	return result, nil
+}
+
+func toNRGBA(img image.Image) *image.NRGBA {
+	if nrgba, ok := img.(*image.NRGBA); ok && nrgba.Bounds().Min == (image.Point{}) {
+		return nrgba
+	}
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
+
+func encodeJPEG(img *image.NRGBA) (Image, error) {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	if err != nil {
+		return Image{}, err
+	}
+	return Image{Data: buf.Bytes(), ContentType: "image/jpeg", Ext: ".jpg", Width: img.Bounds().Dx(), Height: img.Bounds().Dy()}, nil
+}
+
+func encodePNG(img *image.NRGBA) (Image, error) {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		return Image{}, err
+	}
+	return Image{Data: buf.Bytes(), ContentType: "image/png", Ext: ".png", Width: img.Bounds().Dx(), Height: img.Bounds().Dy()}, nil
+}
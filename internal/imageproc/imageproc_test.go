@@ -0,0 +1,231 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// pngHeader returns just the signature and IHDR chunk of a PNG, which is
+// all image.DecodeConfig reads.
+func pngHeader(w, h int) []byte {
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:4], uint32(w))
+	binary.BigEndian.PutUint32(ihdr[4:8], uint32(h))
+	ihdr[8] = 8 // bit depth
+	ihdr[9] = 6 // RGBA
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&buf, binary.BigEndian, uint32(len(ihdr)))
+	chunk := append([]byte("IHDR"), ihdr...)
+	buf.Write(chunk)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return buf.Bytes()
+}
+
+func encodeGIF(t *testing.T, screenW, screenH, frames int) []byte {
+	t.Helper()
+	anim := &gif.GIF{Config: image.Config{Width: screenW, Height: screenH, ColorModel: color.Palette(palette.Plan9)}}
+	for i := 0; i < frames; i++ {
+		anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, 2, 2), palette.Plan9))
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var buf bytes.Buffer
+	err := gif.EncodeAll(&buf, anim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCheckDimensions(t *testing.T) {
+	tests := []struct {
+		name    string
+		w, h    int
+		wantErr error
+	}{
+		{name: "small", w: 640, h: 480},
+		{name: "at the side limit", w: MaxDimension, h: 1},
+		{name: "too wide", w: MaxDimension + 1, h: 1, wantErr: ErrTooLarge},
+		{name: "too tall", w: 1, h: MaxDimension + 1, wantErr: ErrTooLarge},
+		{name: "too many pixels", w: 5000, h: 5000, wantErr: ErrTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CheckDimensions(pngHeader(tt.w, tt.h))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("CheckDimensions(%dx%d) error = %v, want %v", tt.w, tt.h, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProcessGIFLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		w, h    int
+		frames  int
+		wantErr error
+	}{
+		{name: "small animation", w: 10, h: 10, frames: 3},
+		{name: "frames times screen over budget", w: 4000, h: 4000, frames: MaxGIFPixels/(4000*4000) + 1, wantErr: ErrTooLarge},
+		{name: "too many frames", w: 2, h: 2, frames: MaxGIFFrames + 1, wantErr: ErrTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Process(encodeGIF(t, tt.w, tt.h, tt.frames), "image/gif", DefaultVariants)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Process error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			anim, err := gif.DecodeAll(bytes.NewReader(result.Original.Data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(anim.Image) != tt.frames {
+				t.Errorf("original has %d frames, want %d", len(anim.Image), tt.frames)
+			}
+			if len(result.Variants) != len(DefaultVariants) {
+				t.Errorf("got %d variants, want %d", len(result.Variants), len(DefaultVariants))
+			}
+		})
+	}
+}
+
+func TestCheckGIFFramesMalformed(t *testing.T) {
+	data := encodeGIF(t, 10, 10, 2)
+	screen := image.Config{Width: 10, Height: 10}
+	if err := checkGIFFrames(data, screen); err != nil {
+		t.Fatalf("checkGIFFrames on a valid gif: %v", err)
+	}
+	// drop the trailer and part of the last frame
+	if err := checkGIFFrames(data[:len(data)-4], screen); !errors.Is(err, errMalformedGIF) {
+		t.Errorf("checkGIFFrames on a truncated gif error = %v, want errMalformedGIF", err)
+	}
+}
+
+// exifJPEG inserts an APP1 segment with the given orientation right after
+// the SOI marker of a JPEG.
+func exifJPEG(data []byte, order binary.ByteOrder, orientation int) []byte {
+	tiff := make([]byte, 8+2+12+4)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:4], 42)
+	order.PutUint32(tiff[4:8], 8)
+	order.PutUint16(tiff[8:10], 1)
+	order.PutUint16(tiff[10:12], 0x0112)
+	order.PutUint16(tiff[12:14], 3) // SHORT
+	order.PutUint32(tiff[14:18], 1)
+	order.PutUint16(tiff[18:20], uint16(orientation))
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:4], uint16(len(segment)+2))
+	out := append([]byte{}, data[:2]...)
+	out = append(out, app1...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 4, 2)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := buf.Bytes()
+	if got := jpegOrientation(plain); got != 1 {
+		t.Errorf("jpegOrientation without EXIF = %d, want 1", got)
+	}
+	if got := jpegOrientation([]byte("not a jpeg")); got != 1 {
+		t.Errorf("jpegOrientation of garbage = %d, want 1", got)
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for orientation := 1; orientation <= 8; orientation++ {
+			if got := jpegOrientation(exifJPEG(plain, order, orientation)); got != orientation {
+				t.Errorf("jpegOrientation(%v, %d) = %d", order, orientation, got)
+			}
+		}
+		if got := jpegOrientation(exifJPEG(plain, order, 9)); got != 1 {
+			t.Errorf("jpegOrientation(%v, 9) = %d, want 1", order, got)
+		}
+	}
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// a 3x2 image whose pixels are numbered 0-5 in reading order
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for i := 0; i < 6; i++ {
+		src.SetNRGBA(i%3, i/3, color.NRGBA{R: uint8(i), A: 255})
+	}
+	tests := []struct {
+		orientation int
+		want        [][]uint8
+	}{
+		{1, [][]uint8{{0, 1, 2}, {3, 4, 5}}},
+		{2, [][]uint8{{2, 1, 0}, {5, 4, 3}}},
+		{3, [][]uint8{{5, 4, 3}, {2, 1, 0}}},
+		{4, [][]uint8{{3, 4, 5}, {0, 1, 2}}},
+		{5, [][]uint8{{0, 3}, {1, 4}, {2, 5}}},
+		{6, [][]uint8{{3, 0}, {4, 1}, {5, 2}}},
+		{7, [][]uint8{{5, 2}, {4, 1}, {3, 0}}},
+		{8, [][]uint8{{2, 5}, {1, 4}, {0, 3}}},
+	}
+	for _, tt := range tests {
+		got := applyOrientation(src, tt.orientation)
+		if got.Bounds().Dy() != len(tt.want) || got.Bounds().Dx() != len(tt.want[0]) {
+			t.Errorf("orientation %d: size %v, want %dx%d", tt.orientation, got.Bounds().Size(), len(tt.want[0]), len(tt.want))
+			continue
+		}
+		for y, row := range tt.want {
+			for x, want := range row {
+				if r := got.NRGBAAt(x, y).R; r != want {
+					t.Errorf("orientation %d: pixel (%d,%d) = %d, want %d", tt.orientation, x, y, r, want)
+				}
+			}
+		}
+	}
+}
+
+func TestProcessAppliesOrientation(t *testing.T) {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 40, 20)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := exifJPEG(buf.Bytes(), binary.BigEndian, 6)
+	result, err := Process(data, "image/jpeg", DefaultVariants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Original.Width != 20 || result.Original.Height != 40 {
+		t.Errorf("original is %dx%d, want 20x40", result.Original.Width, result.Original.Height)
+	}
+	if jpegOrientation(result.Original.Data) != 1 {
+		t.Errorf("re-encoded original still carries an EXIF orientation")
+	}
+}
+
+func TestProcessUnsupported(t *testing.T) {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 1, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Process(buf.Bytes(), "image/webp", DefaultVariants)
+	if !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Process error = %v, want ErrUnsupported", err)
+	}
+}
@@ -0,0 +1,239 @@
+// Package jwtkeys signs and verifies access tokens with a rotating set of
+// asymmetric keys. Each token names its key in the kid header, and the
+// public halves are published as a JWKS document so other services can
+// verify tokens without sharing a secret.
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	EdDSA = "EdDSA"
+	RS256 = "RS256"
+)
+
+// Issuer matches the tokens internal/auth.MakeJWT has always issued.
+const Issuer = "chirpy"
+
+const rsaKeyBits = 2048
+
+var ErrUnknownKey = errors.New("jwtkeys: token signed with an unknown key")
+var ErrNoSigningKey = errors.New("jwtkeys: no signing key")
+
+// Key is one signing key. A key with a zero ExpiresAt is current; a retired
+// key keeps verifying tokens until ExpiresAt.
+type Key struct {
+	ID        string
+	Algorithm string
+	Private   crypto.Signer
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// Generate creates a new key for alg.
+func Generate(alg string, now time.Time) (Key, error) {
+	var private crypto.Signer
+	var err error
+	switch alg {
+	case EdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case RS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	default:
+		err = fmt.Errorf("jwtkeys: unsupported algorithm %q", alg)
+	}
+	if err != nil {
+		return Key{}, err
+	}
+	id := make([]byte, 12)
+	_, err = rand.Read(id)
+	if err != nil {
+		return Key{}, err
+	}
+	return Key{ID: base64.RawURLEncoding.EncodeToString(id), Algorithm: alg, Private: private, CreatedAt: now}, nil
+}
+
+// MarshalPrivate encodes the private key as PKCS #8 DER for storage.
+func (k Key) MarshalPrivate() ([]byte, error) {
+	return x509.MarshalPKCS8PrivateKey(k.Private)
+}
+
+// ParsePrivate decodes a key stored by MarshalPrivate and checks it suits alg.
+func ParsePrivate(alg string, der []byte) (crypto.Signer, error) {
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	switch key := parsed.(type) {
+	case ed25519.PrivateKey:
+		if alg == EdDSA {
+			return key, nil
+		}
+	case *rsa.PrivateKey:
+		if alg == RS256 {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("jwtkeys: stored key does not match algorithm %q", alg)
+}
+
+func (k Key) method() jwt.SigningMethod {
+	if k.Algorithm == RS256 {
+		return jwt.SigningMethodRS256
+	}
+	return jwt.SigningMethodEdDSA
+}
+
+// Set holds the keys currently in use. It is safe for concurrent use.
+type Set struct {
+	mu      sync.RWMutex
+	keys    map[string]Key
+	signing string
+	// legacySecret verifies HS256 tokens from before asymmetric signing, so
+	// a deploy does not log everyone out. It never signs.
+	legacySecret string
+}
+
+// NewSet returns an empty set. A non-empty legacySecret also accepts HS256
+// tokens signed with it; leave it empty once those tokens have expired.
+func NewSet(legacySecret string) *Set {
+	return &Set{keys: map[string]Key{}, legacySecret: legacySecret}
+}
+
+// Replace swaps in keys, signing with the newest one that is not retired.
+func (s *Set) Replace(keys []Key) {
+	byID := make(map[string]Key, len(keys))
+	signing := ""
+	for _, key := range keys {
+		byID[key.ID] = key
+		if key.ExpiresAt.IsZero() && (signing == "" || key.CreatedAt.After(byID[signing].CreatedAt)) {
+			signing = key.ID
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = byID
+	s.signing = signing
+}
+
+// Current returns the signing key.
+func (s *Set) Current() (Key, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[s.signing]
+	return key, ok
+}
+
+// MakeJWT issues an access token for userID with the same claims as
+// internal/auth.MakeJWT.
+func (s *Set) MakeJWT(userID uuid.UUID, expiresIn time.Duration) (string, error) {
+	key, ok := s.Current()
+	if !ok {
+		return "", ErrNoSigningKey
+	}
+	now := time.Now().UTC()
+	token := jwt.NewWithClaims(key.method(), jwt.RegisteredClaims{
+		Issuer:    Issuer,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+		Subject:   userID.String(),
+	})
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// ValidateJWT checks the signature, issuer and expiry of token and returns
+// its subject.
+func (s *Set) ValidateJWT(tokenString string) (uuid.UUID, error) {
+	claims := jwt.RegisteredClaims{}
+	methods := []string{EdDSA, RS256}
+	if s.legacySecret != "" {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	_, err := jwt.ParseWithClaims(tokenString, &claims, s.verificationKey,
+		jwt.WithValidMethods(methods),
+		jwt.WithIssuer(Issuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		if errors.Is(err, ErrUnknownKey) {
+			return uuid.Nil, ErrUnknownKey
+		}
+		return uuid.Nil, err
+	}
+	return uuid.Parse(claims.Subject)
+}
+
+func (s *Set) verificationKey(token *jwt.Token) (interface{}, error) {
+	if token.Method.Alg() == jwt.SigningMethodHS256.Alg() {
+		if s.legacySecret == "" {
+			return nil, ErrUnknownKey
+		}
+		return []byte(s.legacySecret), nil
+	}
+	kid, _ := token.Header["kid"].(string)
+	s.mu.RLock()
+	key, ok := s.keys[kid]
+	s.mu.RUnlock()
+	if !ok || (!key.ExpiresAt.IsZero() && time.Now().After(key.ExpiresAt)) {
+		return nil, ErrUnknownKey
+	}
+	if key.Algorithm != token.Method.Alg() {
+		return nil, fmt.Errorf("jwtkeys: key %s is not for %s", kid, token.Method.Alg())
+	}
+	return key.Private.Public(), nil
+}
+
+// JWK is a public key in RFC 7517 form.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public half of every key that can still verify tokens.
+func (s *Set) JWKS() JWKS {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	set := JWKS{Keys: make([]JWK, 0, len(s.keys))}
+	for _, key := range s.keys {
+		jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.Algorithm}
+		switch public := key.Private.Public().(type) {
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
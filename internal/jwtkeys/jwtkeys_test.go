@@ -0,0 +1,270 @@
+package jwtkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func generate(t *testing.T, alg string, createdAt time.Time) Key {
+	t.Helper()
+	key, err := Generate(alg, createdAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, alg := range []string{EdDSA, RS256} {
+		t.Run(alg, func(t *testing.T) {
+			key := generate(t, alg, time.Now())
+			der, err := key.MarshalPrivate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			key.Private, err = ParsePrivate(alg, der)
+			if err != nil {
+				t.Fatal(err)
+			}
+			set := NewSet("")
+			set.Replace([]Key{key})
+			userID := uuid.New()
+			token, err := set.MakeJWT(userID, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := set.ValidateJWT(token)
+			if err != nil || got != userID {
+				t.Errorf("ValidateJWT = %v, %v, want %v", got, err, userID)
+			}
+		})
+	}
+}
+
+func TestParsePrivateWrongAlgorithm(t *testing.T) {
+	der, err := generate(t, EdDSA, time.Now()).MarshalPrivate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParsePrivate(RS256, der); err == nil {
+		t.Errorf("ParsePrivate accepted an Ed25519 key as RS256")
+	}
+}
+
+func TestRotation(t *testing.T) {
+	now := time.Now()
+	old := generate(t, EdDSA, now.Add(-time.Hour))
+	current := generate(t, RS256, now)
+	set := NewSet("")
+	if _, err := set.MakeJWT(uuid.New(), time.Hour); !errors.Is(err, ErrNoSigningKey) {
+		t.Fatalf("MakeJWT on an empty set error = %v, want ErrNoSigningKey", err)
+	}
+
+	set.Replace([]Key{old})
+	oldToken, err := set.MakeJWT(uuid.New(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rotate: the old key is retired but still verifies
+	old.ExpiresAt = now.Add(time.Hour)
+	set.Replace([]Key{old, current})
+	if key, ok := set.Current(); !ok || key.ID != current.ID {
+		t.Fatalf("Current = %s, want %s", key.ID, current.ID)
+	}
+	if _, err := set.ValidateJWT(oldToken); err != nil {
+		t.Errorf("token from a retired key in its grace period: %v", err)
+	}
+	newToken, err := set.MakeJWT(uuid.New(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken, &jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Header["kid"] != current.ID || parsed.Method.Alg() != RS256 {
+		t.Errorf("new token header = %v, want kid %s and alg %s", parsed.Header, current.ID, RS256)
+	}
+
+	// grace period over
+	old.ExpiresAt = now.Add(-time.Second)
+	set.Replace([]Key{old, current})
+	if _, err := set.ValidateJWT(oldToken); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("token from an expired key error = %v, want ErrUnknownKey", err)
+	}
+	set.Replace([]Key{current})
+	if _, err := set.ValidateJWT(oldToken); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("token from a deleted key error = %v, want ErrUnknownKey", err)
+	}
+	if _, err := set.ValidateJWT(newToken); err != nil {
+		t.Errorf("token from the current key: %v", err)
+	}
+}
+
+func TestReplacePicksNewestCurrentKey(t *testing.T) {
+	now := time.Now()
+	older := generate(t, EdDSA, now.Add(-time.Hour))
+	newer := generate(t, EdDSA, now)
+	retired := generate(t, EdDSA, now.Add(time.Hour))
+	retired.ExpiresAt = now.Add(time.Hour)
+	set := NewSet("")
+	set.Replace([]Key{newer, retired, older})
+	if key, ok := set.Current(); !ok || key.ID != newer.ID {
+		t.Errorf("Current = %s, want the newest unretired key %s", key.ID, newer.ID)
+	}
+}
+
+func signHS256(t *testing.T, secret string, claims jwt.RegisteredClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestLegacyHS256(t *testing.T) {
+	userID := uuid.New()
+	claims := jwt.RegisteredClaims{
+		Issuer:    Issuer,
+		Subject:   userID.String(),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+	token := signHS256(t, "legacy", claims)
+	key := generate(t, EdDSA, time.Now())
+
+	set := NewSet("")
+	set.Replace([]Key{key})
+	if _, err := set.ValidateJWT(token); err == nil {
+		t.Errorf("HS256 token accepted without a legacy secret")
+	}
+
+	set = NewSet("legacy")
+	set.Replace([]Key{key})
+	if got, err := set.ValidateJWT(token); err != nil || got != userID {
+		t.Errorf("legacy ValidateJWT = %v, %v, want %v", got, err, userID)
+	}
+	if _, err := set.ValidateJWT(signHS256(t, "other", claims)); err == nil {
+		t.Errorf("HS256 token with the wrong secret accepted")
+	}
+	// an HS256 token naming a signing key must not be checked against it
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	forged.Header["kid"] = key.ID
+	signed, err := forged.SignedString([]byte(key.Private.Public().(ed25519.PublicKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := set.ValidateJWT(signed); err == nil {
+		t.Errorf("HS256 token signed with a public key accepted")
+	}
+}
+
+func TestValidateJWTClaims(t *testing.T) {
+	key := generate(t, EdDSA, time.Now())
+	set := NewSet("")
+	set.Replace([]Key{key})
+	sign := func(claims jwt.RegisteredClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+		token.Header["kid"] = key.ID
+		signed, err := token.SignedString(key.Private)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	subject := uuid.NewString()
+	tests := []struct {
+		name   string
+		claims jwt.RegisteredClaims
+	}{
+		{name: "wrong issuer", claims: jwt.RegisteredClaims{Issuer: "elsewhere", Subject: subject, ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}},
+		{name: "no expiry", claims: jwt.RegisteredClaims{Issuer: Issuer, Subject: subject}},
+		{name: "expired", claims: jwt.RegisteredClaims{Issuer: Issuer, Subject: subject, ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute))}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := set.ValidateJWT(sign(tt.claims)); err == nil {
+				t.Errorf("ValidateJWT accepted a token with %s", tt.name)
+			}
+		})
+	}
+}
+
+// publicKey rebuilds the verification key a relying party would get from
+// jwk.
+func publicKey(t *testing.T, jwk JWK) interface{} {
+	t.Helper()
+	decode := func(s string) []byte {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	switch jwk.KeyType {
+	case "OKP":
+		if jwk.Curve != "Ed25519" || jwk.Algorithm != EdDSA {
+			t.Fatalf("OKP key %+v", jwk)
+		}
+		return ed25519.PublicKey(decode(jwk.X))
+	case "RSA":
+		if jwk.Algorithm != RS256 {
+			t.Fatalf("RSA key %+v", jwk)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(decode(jwk.N)), E: int(new(big.Int).SetBytes(decode(jwk.E)).Int64())}
+	}
+	t.Fatalf("unexpected key type %q", jwk.KeyType)
+	return nil
+}
+
+func TestJWKS(t *testing.T) {
+	now := time.Now()
+	retired := generate(t, EdDSA, now.Add(-time.Hour))
+	current := generate(t, RS256, now)
+	set := NewSet("legacy")
+	set.Replace([]Key{retired})
+	retiredToken, err := set.MakeJWT(uuid.New(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retired.ExpiresAt = now.Add(time.Hour)
+	set.Replace([]Key{retired, current})
+	currentToken, err := set.MakeJWT(uuid.New(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks := set.JWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("JWKS has %d keys, want 2 and never the legacy secret", len(jwks.Keys))
+	}
+	byID := map[string]JWK{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "sig" {
+			t.Errorf("key %s use = %q, want sig", jwk.KeyID, jwk.Use)
+		}
+		byID[jwk.KeyID] = jwk
+	}
+	for _, token := range []string{retiredToken, currentToken} {
+		_, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			jwk, ok := byID[kid]
+			if !ok {
+				return nil, ErrUnknownKey
+			}
+			return publicKey(t, jwk), nil
+		}, jwt.WithValidMethods([]string{EdDSA, RS256}))
+		if err != nil {
+			t.Errorf("verifying with the published JWKS: %v", err)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Dir writes every message to its own .eml file so local developers can
+// open verification links without a mail server.
+type Dir struct {
+	Path string
+	From string
+}
+
+func (d Dir) Send(ctx context.Context, msg Message) error {
+	if err := validHeader(msg.To, msg.Subject); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(d.Path, 0o755); err != nil {
+		return err
+	}
+	now := time.Now()
+	f, err := os.CreateTemp(d.Path, fmt.Sprintf("%s-*.eml", now.UTC().Format("20060102T150405")))
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(format(d.From, msg, now)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Memory keeps sent messages in memory, for tests.
+type Memory struct {
+	mu   sync.Mutex
+	sent []Message
+}
+
+func (m *Memory) Send(ctx context.Context, msg Message) error {
+	if err := validHeader(msg.To, msg.Subject); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// Sent returns a copy of every message sent so far.
+func (m *Memory) Sent() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.sent...)
+}
@@ -0,0 +1,67 @@
+// Package mailer sends transactional email such as verification links. The
+// SMTP backend is used in production, the file and memory backends let the
+// server run and be tested without a mail relay.
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers a single message.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New builds the mailer named by kind: "smtp", "file" or "memory".
+func New(kind string, smtpConfig SMTP, dir string) (Mailer, error) {
+	switch kind {
+	case "smtp":
+		if smtpConfig.Host == "" {
+			return nil, fmt.Errorf("mailer: smtp needs a host")
+		}
+		if smtpConfig.Port == "" {
+			smtpConfig.Port = "587"
+		}
+		return smtpConfig, nil
+	case "file", "":
+		return Dir{Path: filepath.Clean(dir), From: smtpConfig.From}, nil
+	case "memory":
+		return &Memory{}, nil
+	}
+	return nil, fmt.Errorf("mailer: unknown mailer %q", kind)
+}
+
+// format renders msg as an RFC 5322 message with CRLF line endings.
+func format(from string, msg Message, now time.Time) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// validHeader rejects values that would let a caller inject extra headers.
+func validHeader(values ...string) error {
+	for _, v := range values {
+		if strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("mailer: header value %q contains a newline", v)
+		}
+	}
+	return nil
+}
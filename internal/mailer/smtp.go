@@ -0,0 +1,39 @@
+package mailer
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// SMTP sends mail through a relay. Auth is only used when Username is set;
+// net/smtp refuses PLAIN auth over an unencrypted connection to anything but
+// localhost, and upgrades to TLS with STARTTLS when the server offers it.
+type SMTP struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (s SMTP) Send(ctx context.Context, msg Message) error {
+	if err := validHeader(msg.To, msg.Subject); err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- smtp.SendMail(net.JoinHostPort(s.Host, s.Port), auth, s.From, []string{msg.To}, format(s.From, msg, time.Now()))
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
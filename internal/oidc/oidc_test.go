@@ -0,0 +1,502 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID     = "chirpy"
+	testClientSecret = "client secret"
+	testRedirectURL  = "https://chirpy.example/api/oidc/test/callback"
+	testNonce        = "n0nce"
+)
+
+// mockIdP serves discovery, JWKS and token endpoints for one client.
+type mockIdP struct {
+	server *httptest.Server
+
+	mu          sync.Mutex
+	keys        map[string]crypto.Signer
+	jwksFetches int
+	// jwksGate, when set, holds JWKS requests until it is closed
+	jwksGate    chan struct{}
+	jwksEntered chan struct{}
+	// codes maps issued authorization codes to their PKCE challenge
+	codes   map[string]string
+	idToken string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	m := &mockIdP{keys: map[string]crypto.Signer{}, codes: map[string]string{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("GET /jwks", m.jwks)
+	mux.HandleFunc("POST /token", m.token)
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockIdP) issuer() string {
+	return m.server.URL
+}
+
+func (m *mockIdP) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(Metadata{
+		Issuer:                m.issuer(),
+		AuthorizationEndpoint: m.issuer() + "/authorize",
+		TokenEndpoint:         m.issuer() + "/token",
+		JWKSURI:               m.issuer() + "/jwks",
+	})
+}
+
+func (m *mockIdP) jwks(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	m.jwksFetches++
+	gate, entered := m.jwksGate, m.jwksEntered
+	keys := []map[string]string{}
+	for kid, signer := range m.keys {
+		keys = append(keys, publicJWK(kid, signer.Public()))
+	}
+	m.mu.Unlock()
+	if gate != nil {
+		close(entered)
+		<-gate
+	}
+	json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+}
+
+func (m *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, _ := r.BasicAuth()
+	m.mu.Lock()
+	challenge, ok := m.codes[r.PostFormValue("code")]
+	delete(m.codes, r.PostFormValue("code"))
+	idToken := m.idToken
+	m.mu.Unlock()
+	switch {
+	case clientID != testClientID || clientSecret != url.QueryEscape(testClientSecret):
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+	case r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("redirect_uri") != testRedirectURL ||
+		!ok || Challenge(r.PostFormValue("code_verifier")) != challenge:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+	default:
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "opaque", "token_type": "Bearer", "id_token": idToken})
+	}
+}
+
+func (m *mockIdP) addKey(kid string, signer crypto.Signer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[kid] = signer
+}
+
+func (m *mockIdP) removeKey(kid string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.keys, kid)
+}
+
+func (m *mockIdP) fetches() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.jwksFetches
+}
+
+func publicJWK(kid string, public crypto.PublicKey) map[string]string {
+	enc := base64.RawURLEncoding.EncodeToString
+	switch key := public.(type) {
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "kid": kid, "use": "sig", "crv": "P-256",
+			"x": enc(key.X.FillBytes(make([]byte, 32))), "y": enc(key.Y.FillBytes(make([]byte, 32)))}
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "use": "sig",
+			"n": enc(key.N.Bytes()), "e": enc(big.NewInt(int64(key.E)).Bytes())}
+	}
+	panic("unsupported key")
+}
+
+func newECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// sign makes an ID token; an empty kid leaves the header out.
+func sign(t *testing.T, kid string, signer crypto.Signer, claims jwt.MapClaims) string {
+	t.Helper()
+	method := jwt.SigningMethod(jwt.SigningMethodES256)
+	if _, ok := signer.(*rsa.PrivateKey); ok {
+		method = jwt.SigningMethodRS256
+	}
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func (m *mockIdP) claims(now time.Time) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            m.issuer(),
+		"sub":            "248289761001",
+		"aud":            testClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          testNonce,
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"name":           "Alice",
+	}
+}
+
+// provider returns a client of m whose clock is *now.
+func (m *mockIdP) provider(now *time.Time) *Provider {
+	return New(Config{
+		Issuer:       m.issuer(),
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+		HTTPClient:   m.server.Client(),
+		Now:          func() time.Time { return *now },
+	})
+}
+
+func TestAuthenticate(t *testing.T) {
+	m := newMockIdP(t)
+	key := newECKey(t)
+	m.addKey("k1", key)
+	now := time.Now()
+	p := m.provider(&now)
+	ctx := context.Background()
+
+	verifier, err := NewVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := p.AuthCodeURL(ctx, "st4te", testNonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          testRedirectURL,
+		"scope":                 "openid email profile",
+		"state":                 "st4te",
+		"nonce":                 testNonce,
+		"code_challenge":        Challenge(verifier),
+		"code_challenge_method": "S256",
+	}
+	for name, value := range want {
+		if got := query.Get(name); got != value {
+			t.Errorf("authorization URL %s = %q, want %q", name, got, value)
+		}
+	}
+
+	m.mu.Lock()
+	m.codes["c0de"] = query.Get("code_challenge")
+	m.codes["c0de2"] = query.Get("code_challenge")
+	m.idToken = sign(t, "k1", key, m.claims(now))
+	m.mu.Unlock()
+	claims, err := p.Authenticate(ctx, "c0de", verifier, testNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantClaims := Claims{Subject: "248289761001", Email: "alice@example.com", EmailVerified: true, Name: "Alice"}
+	if claims != wantClaims {
+		t.Errorf("Authenticate = %+v, want %+v", claims, wantClaims)
+	}
+	if _, err := p.Authenticate(ctx, "c0de", verifier, testNonce); err == nil {
+		t.Errorf("Authenticate accepted a code twice")
+	}
+	if _, err := p.Authenticate(ctx, "c0de2", "wrong verifier", testNonce); err == nil {
+		t.Errorf("Authenticate accepted the wrong PKCE verifier")
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	m := newMockIdP(t)
+	now := time.Now()
+	p := New(Config{Issuer: m.issuer() + "/tenant", ClientID: testClientID, HTTPClient: m.server.Client(), Now: func() time.Time { return now }})
+	// the mock has no /tenant discovery document
+	if _, err := p.Metadata(context.Background()); err == nil {
+		t.Errorf("Metadata succeeded without a discovery document")
+	}
+	p = New(Config{Issuer: m.issuer() + "/", ClientID: testClientID, HTTPClient: m.server.Client()})
+	if _, err := p.Metadata(context.Background()); err == nil {
+		t.Errorf("Metadata accepted a document for issuer %q", m.issuer())
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	m := newMockIdP(t)
+	key := newECKey(t)
+	m.addKey("k1", key)
+	now := time.Now()
+	p := m.provider(&now)
+	tests := []struct {
+		name    string
+		edit    func(jwt.MapClaims)
+		wantErr error
+		fail    bool
+	}{
+		{name: "valid", edit: func(c jwt.MapClaims) {}},
+		{name: "bad nonce", edit: func(c jwt.MapClaims) { c["nonce"] = "other" }, wantErr: ErrNonceMismatch},
+		{name: "missing nonce", edit: func(c jwt.MapClaims) { delete(c, "nonce") }, wantErr: ErrNonceMismatch},
+		{name: "wrong audience", edit: func(c jwt.MapClaims) { c["aud"] = "someone-else" }, fail: true},
+		{name: "wrong audience with our azp", edit: func(c jwt.MapClaims) { c["aud"] = "someone-else"; c["azp"] = testClientID }, fail: true},
+		{name: "several audiences without azp", edit: func(c jwt.MapClaims) { c["aud"] = []string{testClientID, "someone-else"} }, fail: true},
+		{name: "several audiences with another azp", edit: func(c jwt.MapClaims) {
+			c["aud"] = []string{testClientID, "someone-else"}
+			c["azp"] = "someone-else"
+		}, fail: true},
+		{name: "several audiences with our azp", edit: func(c jwt.MapClaims) {
+			c["aud"] = []string{testClientID, "someone-else"}
+			c["azp"] = testClientID
+		}},
+		{name: "wrong issuer", edit: func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }, fail: true},
+		{name: "expired", edit: func(c jwt.MapClaims) { c["exp"] = now.Add(-2 * time.Minute).Unix() }, fail: true},
+		{name: "expired within leeway", edit: func(c jwt.MapClaims) { c["exp"] = now.Add(-30 * time.Second).Unix() }},
+		{name: "no expiry", edit: func(c jwt.MapClaims) { delete(c, "exp") }, fail: true},
+		{name: "issued in the future", edit: func(c jwt.MapClaims) { c["iat"] = now.Add(5 * time.Minute).Unix() }, fail: true},
+		{name: "no subject", edit: func(c jwt.MapClaims) { delete(c, "sub") }, fail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := m.claims(now)
+			tt.edit(claims)
+			_, err := p.VerifyIDToken(context.Background(), sign(t, "k1", key, claims), testNonce)
+			switch {
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Errorf("VerifyIDToken error = %v, want %v", err, tt.wantErr)
+			case tt.fail && err == nil:
+				t.Errorf("VerifyIDToken accepted the token")
+			case tt.wantErr == nil && !tt.fail && err != nil:
+				t.Errorf("VerifyIDToken: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenExpiresWithClock(t *testing.T) {
+	m := newMockIdP(t)
+	key := newECKey(t)
+	m.addKey("k1", key)
+	now := time.Now()
+	p := m.provider(&now)
+	token := sign(t, "k1", key, m.claims(now))
+	if _, err := p.VerifyIDToken(context.Background(), token, testNonce); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Hour + 2*time.Minute)
+	if _, err := p.VerifyIDToken(context.Background(), token, testNonce); !errors.Is(err, jwt.ErrTokenExpired) {
+		t.Errorf("VerifyIDToken an hour later error = %v, want ErrTokenExpired", err)
+	}
+}
+
+func TestVerifyIDTokenRejectsUnsignedAlgorithms(t *testing.T) {
+	m := newMockIdP(t)
+	m.addKey("k1", newECKey(t))
+	now := time.Now()
+	p := m.provider(&now)
+	none, err := jwt.NewWithClaims(jwt.SigningMethodNone, m.claims(now)).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmac := jwt.NewWithClaims(jwt.SigningMethodHS256, m.claims(now))
+	hmac.Header["kid"] = "k1"
+	hs256, err := hmac.SignedString([]byte(testClientSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, token := range map[string]string{"none": none, "HS256": hs256} {
+		if _, err := p.VerifyIDToken(context.Background(), token, testNonce); err == nil {
+			t.Errorf("VerifyIDToken accepted an %s token", name)
+		}
+	}
+}
+
+func TestVerifyIDTokenEmailVerified(t *testing.T) {
+	m := newMockIdP(t)
+	key := newECKey(t)
+	m.addKey("k1", key)
+	now := time.Now()
+	p := m.provider(&now)
+	tests := []struct {
+		name  string
+		value any
+		want  bool
+	}{
+		{name: "true", value: true, want: true},
+		{name: "string true", value: "true", want: true},
+		{name: "false", value: false},
+		{name: "string false", value: "false"},
+		{name: "null", value: nil},
+		{name: "missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := m.claims(now)
+			delete(claims, "email_verified")
+			if tt.name != "missing" {
+				claims["email_verified"] = tt.value
+			}
+			got, err := p.VerifyIDToken(context.Background(), sign(t, "k1", key, claims), testNonce)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.EmailVerified != tt.want || got.Email != "alice@example.com" {
+				t.Errorf("claims = %+v, want EmailVerified %v", got, tt.want)
+			}
+		})
+	}
+	claims := m.claims(now)
+	claims["email_verified"] = "yes"
+	if _, err := p.VerifyIDToken(context.Background(), sign(t, "k1", key, claims), testNonce); err == nil {
+		t.Errorf("VerifyIDToken accepted email_verified %q", "yes")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	m := newMockIdP(t)
+	k1 := newECKey(t)
+	k2, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.addKey("k1", k1)
+	now := time.Now()
+	p := m.provider(&now)
+	ctx := context.Background()
+	verify := func(kid string, signer crypto.Signer) error {
+		_, err := p.VerifyIDToken(ctx, sign(t, kid, signer, m.claims(now)), testNonce)
+		return err
+	}
+
+	if err := verify("k1", k1); err != nil {
+		t.Fatal(err)
+	}
+	// a token without a kid is fine while there is one key
+	if err := verify("", k1); err != nil {
+		t.Errorf("token without a kid: %v", err)
+	}
+	if got := m.fetches(); got != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", got)
+	}
+
+	// the provider rotates, but the keys were fetched too recently to
+	// fetch them again for every unknown kid
+	m.addKey("k2", k2)
+	if err := verify("k2", k2); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("new kid within the refresh interval error = %v, want ErrUnknownKey", err)
+	}
+	if got := m.fetches(); got != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", got)
+	}
+	now = now.Add(keyRefreshInterval)
+	if err := verify("k2", k2); err != nil {
+		t.Errorf("new kid after the refresh interval: %v", err)
+	}
+	if got := m.fetches(); got != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", got)
+	}
+	if err := verify("", k1); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("token without a kid among several keys error = %v, want ErrUnknownKey", err)
+	}
+
+	// the old key is withdrawn and disappears at the next fetch
+	m.removeKey("k1")
+	if err := verify("k1", k1); err != nil {
+		t.Errorf("cached key before the next fetch: %v", err)
+	}
+	now = now.Add(keyRefreshInterval)
+	if err := verify("k3", k1); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("unknown kid error = %v, want ErrUnknownKey", err)
+	}
+	if err := verify("k1", k1); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("withdrawn key error = %v, want ErrUnknownKey", err)
+	}
+	// a signature from the wrong key under a known kid
+	if err := verify("k2", newECKey(t)); err == nil || errors.Is(err, ErrUnknownKey) {
+		t.Errorf("forged signature error = %v, want a verification error", err)
+	}
+}
+
+func TestKeyFetchDoesNotBlockKnownKeys(t *testing.T) {
+	m := newMockIdP(t)
+	k1, k2 := newECKey(t), newECKey(t)
+	m.addKey("k1", k1)
+	now := time.Now()
+	p := m.provider(&now)
+	ctx := context.Background()
+	k1Token := sign(t, "k1", k1, m.claims(now))
+	if _, err := p.VerifyIDToken(ctx, k1Token, testNonce); err != nil {
+		t.Fatal(err)
+	}
+
+	gate := make(chan struct{})
+	m.mu.Lock()
+	m.jwksGate = gate
+	m.jwksEntered = make(chan struct{})
+	entered := m.jwksEntered
+	m.mu.Unlock()
+	m.addKey("k2", k2)
+	now = now.Add(keyRefreshInterval)
+	k2Token := sign(t, "k2", k2, m.claims(now))
+	slow := make(chan error, 1)
+	go func() {
+		_, err := p.VerifyIDToken(ctx, k2Token, testNonce)
+		slow <- err
+	}()
+	<-entered
+
+	fast := make(chan error, 1)
+	go func() {
+		_, err := p.VerifyIDToken(ctx, k1Token, testNonce)
+		fast <- err
+	}()
+	select {
+	case err := <-fast:
+		if err != nil {
+			t.Errorf("known key during a JWKS fetch: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("verifying with a known key waited for the JWKS fetch")
+	}
+	close(gate)
+	if err := <-slow; err != nil {
+		t.Errorf("new key after the fetch: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package totp
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 seed from RFC 6238 appendix B.
+var rfcSecret = []byte("12345678901234567890")
+
+func TestCodeRFC6238(t *testing.T) {
+	// the RFC lists eight digit codes, six digit codes are their last six
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	tests := []struct {
+		name   string
+		code   string
+		skew   int
+		want   bool
+		wantAt int64
+	}{
+		{name: "current step", code: Code(rfcSecret, step), skew: 1, want: true, wantAt: step},
+		{name: "spaces are ignored", code: "050 471", skew: 1, want: true, wantAt: step},
+		{name: "previous step within skew", code: Code(rfcSecret, step-1), skew: 1, want: true, wantAt: step - 1},
+		{name: "next step within skew", code: Code(rfcSecret, step+1), skew: 1, want: true, wantAt: step + 1},
+		{name: "previous step without skew", code: Code(rfcSecret, step-1), skew: 0},
+		{name: "two steps out", code: Code(rfcSecret, step-2), skew: 1},
+		{name: "wrong length", code: "05047", skew: 1},
+		{name: "wrong code", code: "000000", skew: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at, ok := Validate(rfcSecret, tt.code, now, tt.skew)
+			if ok != tt.want || (ok && at != tt.wantAt) {
+				t.Errorf("Validate(%q) = %d, %v, want %d, %v", tt.code, at, ok, tt.wantAt, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecretRoundTrip(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != SecretSize {
+		t.Fatalf("NewSecret returned %d bytes, want %d", len(secret), SecretSize)
+	}
+	encoded := EncodeSecret(secret)
+	decoded, err := DecodeSecret(encoded)
+	if err != nil || !bytes.Equal(decoded, secret) {
+		t.Errorf("DecodeSecret(EncodeSecret) = %x, %v, want %x", decoded, err, secret)
+	}
+	// authenticator apps show the secret in lower case groups of four
+	var spaced []byte
+	for i, c := range []byte(encoded) {
+		if i > 0 && i%4 == 0 {
+			spaced = append(spaced, ' ')
+		}
+		spaced = append(spaced, c|0x20)
+	}
+	decoded, err = DecodeSecret(string(spaced) + "====")
+	if err != nil || !bytes.Equal(decoded, secret) {
+		t.Errorf("DecodeSecret(%q) = %x, %v, want %x", spaced, decoded, err, secret)
+	}
+}
+
+func TestURI(t *testing.T) {
+	u, err := url.Parse(URI("Chirpy", "alice@example.com", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Chirpy:alice@example.com" {
+		t.Errorf("URI = %s, want otpauth://totp/Chirpy:alice@example.com", u)
+	}
+	params := u.Query()
+	if got, want := params.Get("secret"), EncodeSecret(rfcSecret); got != want {
+		t.Errorf("secret = %s, want %s", got, want)
+	}
+	if params.Get("issuer") != "Chirpy" || params.Get("digits") != "6" || params.Get("period") != "30" {
+		t.Errorf("URI parameters = %v", params)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/xsynch/chirpy/internal/database"
+)
+
+func (cfg *apiConfig) likeChirp(w http.ResponseWriter, r *http.Request){
+	userID := authUserID(r)
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid chirp id"))
+		return
+	}
+	_, err = cfg.db.GetOneChirp(r.Context(), chirpID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Error finding chirp"))
+		log.Printf("There was an error getting chirp %v: %s", chirpID, err)
+		return
+	}
+	// liking an already liked chirp is a no-op
+	params := database.LikeChirpParams{UserID: userID, ChirpID: chirpID}
+	err = cfg.db.LikeChirp(r.Context(), params)
+	if err != nil {
+		log.Printf("Error liking chirp %v for user %v: %s", chirpID, userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) unlikeChirp(w http.ResponseWriter, r *http.Request){
+	userID := authUserID(r)
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid chirp id"))
+		return
+	}
+	params := database.UnlikeChirpParams{UserID: userID, ChirpID: chirpID}
+	err = cfg.db.UnlikeChirp(r.Context(), params)
+	if err != nil {
+		log.Printf("Error unliking chirp %v for user %v: %s", chirpID, userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getUserLikes pages the chirps a user liked, most recently liked first.
+// The cursor is the time of the like and the chirp id.
+func (cfg *apiConfig) getUserLikes(w http.ResponseWriter, r *http.Request){
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid user id"))
+		return
+	}
+	page, err := parsePageRequest(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	params := database.GetLikedChirpsParams{UserID: userID, PageSize: int32(page.Limit + 1)}
+	params.CursorCreatedAt, params.CursorID = page.cursorArgs()
+	results, err := cfg.db.GetLikedChirps(r.Context(), params)
+	if err != nil {
+		log.Printf("Error getting chirps liked by %v: %s", userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error getting likes"))
+		return
+	}
+	likesPage := chirpPage{}
+	if len(results) > page.Limit {
+		results = results[:page.Limit]
+		last := results[page.Limit-1]
+		likesPage.NextCursor = encodeCursor(chirpCursor{CreatedAt: last.LikedAt, ID: last.ID})
+	}
+	chirps := make([]database.Chirp, 0, len(results))
+	for _, val := range results {
+		chirps = append(chirps, chirpFromColumns(chirpColumns{val.ID, val.CreatedAt, val.UpdatedAt, val.Body, val.UserID, val.InReplyTo, val.QuotedChirpID}))
+	}
+	likesPage.Chirps, err = cfg.buildChirpResponses(r.Context(), chirps, cfg.optionalUserID(r))
+	if err != nil {
+		log.Printf("Error building chirps liked by %v: %s", userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error getting likes"))
+		return
+	}
+	dst, err := json.Marshal(likesPage)
+	if err != nil {
+		log.Printf("Error marshalling %v: %s", likesPage, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error getting likes"))
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dst)
+}
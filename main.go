@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"path"
+	"path/filepath"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -17,7 +21,12 @@ import (
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 	"github.com/xsynch/chirpy/internal/auth"
+	"github.com/xsynch/chirpy/internal/blobstore"
 	"github.com/xsynch/chirpy/internal/database"
+	"github.com/xsynch/chirpy/internal/filter"
+	"github.com/xsynch/chirpy/internal/jwtkeys"
+	"github.com/xsynch/chirpy/internal/mailer"
+	"github.com/xsynch/chirpy/internal/oidc"
 )
 
 
@@ -65,6 +74,11 @@ func (cfg *apiConfig) createUsers(w http.ResponseWriter, r *http.Request){
 	if err != nil {
 		log.Printf("Error unmarshalling json: %s with error: %s", r.Body, err)
 	}
+	if !validEmail(userRequest.Email) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("A valid email address is required"))
+		return
+	}
 	password, err := auth.HashPassword(userRequest.Password)
 	if err != nil {
 		log.Fatalf("Error hashing password %s: %s", userRequest.Password, err)
@@ -74,12 +88,19 @@ func (cfg *apiConfig) createUsers(w http.ResponseWriter, r *http.Request){
 	if err != nil {
 		log.Fatalf("Error creating user %s: %s", userRequest.Email,err )
 	}
+	// the account exists either way, a failed send can be retried through
+	// /api/users/verify/resend
+	err = cfg.sendVerificationEmail(r.Context(), user.ID, user.Email)
+	if err != nil {
+		log.Printf("Error sending verification email to %v: %s", user.ID, err)
+	}
 	dbuser := createDBUserResponse{
 		ID: user.ID,
 		CreatedAt: user.CreatedAt,
 		UpdatedAt: user.UpdatedAt,
 		Email: user.Email,
 		IsChirpyRd: user.IsChirpyRed,
+		EmailVerified: user.EmailVerifiedAt.Valid,
 	}
 	dst, err := json.Marshal(dbuser)
 	if err != nil {
@@ -105,29 +126,50 @@ func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 
 func (cfg *apiConfig) addHeaders(next http.Handler) http.Handler{
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
-		w.Header().Add("Cache-Control","no-cache")
+		if cfg.isMediaPath(r.URL.Path) {
+			w.Header().Add("Cache-Control","public, max-age=31536000, immutable")
+		} else {
+			w.Header().Add("Cache-Control","no-cache")
+		}
+		next.ServeHTTP(w,r)
+	})
+}
+
+// hidePaths keeps the file server from serving directories that only the
+// API should read, such as data exports and locally written mail.
+func hidePaths(prefixes []string, next http.Handler) http.Handler{
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
+		urlPath := path.Clean("/" + r.URL.Path)
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(urlPath + "/", prefix) {
+				http.NotFound(w, r)
+				return
+			}
+		}
 		next.ServeHTTP(w,r)
 	})
 }
 
 func (cfg *apiConfig) createChirp(w http.ResponseWriter, r *http.Request){
-	headerToken, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte("User must be logged in"))
-		return
-	}
-	tokenUserID,err := auth.ValidateJWT(headerToken, cfg.secret)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte("bad token"))
-		log.Printf("%s",err)
-		return 
+	tokenUserID := authUserID(r)
+	if cfg.require_verified_email {
+		author, err := cfg.db.GetUserByID(r.Context(), tokenUserID)
+		if err != nil {
+			log.Printf("Error looking up user %v: %s", tokenUserID, err)
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Invalid User"))
+			return
+		}
+		if !author.EmailVerifiedAt.Valid {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Verify your email address before posting"))
+			return
+		}
 	}
 	// log.Println(tokenUserID)
 	decoder := json.NewDecoder(r.Body)
 	chirps := incomingChirp{}
-	err = decoder.Decode(&chirps)
+	err := decoder.Decode(&chirps)
 	if err != nil {
 		log.Printf("Error decoding chirp: %s", err)
 		ce := chirpError{
@@ -161,26 +203,121 @@ func (cfg *apiConfig) createChirp(w http.ResponseWriter, r *http.Request){
 		w.Write(dst)
 		return 
 	}
-	params := database.InsertChirpParams{
-		Body: chirps.Body,
-		// UserID: chirps.UserID,
-		UserID: tokenUserID,
+	filtered := cfg.contentFilter.Check(chirps.Body)
+	if filtered.Rejected {
+		ce := chirpError{
+			Error: "Chirp contains prohibited words",
+		}
+		dst, err := json.Marshal(ce)
+		if err != nil {
+			log.Printf("Error marshalling json: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+		w.Header().Set("Content-Type","application/json")
+		w.WriteHeader(400)
+		w.Write(dst)
+		return
 	}
-	
-	newChirp, err := cfg.db.InsertChirp(r.Context(),params)
+	chirps.Body = filtered.Body
+	err = cfg.checkChirpMedia(r.Context(), tokenUserID, chirps.MediaIDs)
 	if err != nil {
-		log.Printf("error inserting %v into db: %s", params, err)
-		return 
+		log.Printf("Invalid media for chirp by %v: %s", tokenUserID, err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	// the chirp, its entities and its media are saved together, so a failure
+	// never leaves a chirp with half of its attachments
+	tx, err := cfg.sqlDB.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+	var newChirp database.Chirp
+	if chirps.InReplyTo != nil || chirps.QuotedChirpID != nil {
+		params := database.InsertChirpWithRefsParams{
+			Body: chirps.Body,
+			UserID: tokenUserID,
+		}
+		if chirps.InReplyTo != nil {
+			_, err = cfg.db.GetOneChirp(r.Context(), *chirps.InReplyTo)
+			if err != nil {
+				log.Printf("Could not find parent chirp %v: %s", *chirps.InReplyTo, err)
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Parent chirp not found"))
+				return
+			}
+			params.InReplyTo = uuid.NullUUID{UUID: *chirps.InReplyTo, Valid: true}
+		}
+		if chirps.QuotedChirpID != nil {
+			_, err = cfg.db.GetOneChirp(r.Context(), *chirps.QuotedChirpID)
+			if err != nil {
+				log.Printf("Could not find quoted chirp %v: %s", *chirps.QuotedChirpID, err)
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Quoted chirp not found"))
+				return
+			}
+			params.QuotedChirpID = uuid.NullUUID{UUID: *chirps.QuotedChirpID, Valid: true}
+		}
+		var inserted database.InsertChirpWithRefsRow
+		inserted, err = qtx.InsertChirpWithRefs(r.Context(), params)
+		if err != nil {
+			log.Printf("error inserting %v into db: %s", params, err)
+			return
+		}
+		newChirp = chirpFromColumns(chirpColumns(inserted))
+	} else {
+		params := database.InsertChirpParams{
+			Body: chirps.Body,
+			// UserID: chirps.UserID,
+			UserID: tokenUserID,
+		}
+
+		newChirp, err = qtx.InsertChirp(r.Context(),params)
+		if err != nil {
+			log.Printf("error inserting %v into db: %s", params, err)
+			return 
+		}
 	}
 	// log.Printf("Successfully inserted %v into the db", newChirp)
-	cs := chirpSuccess{
-		ID: newChirp.ID,
-		
-		// ID: tokenUserID,
-		CreatedAt: newChirp.CreatedAt,
-		UpdatedAt: newChirp.UpdatedAt,
-		Body: cleanChirp(chirps.Body),
-		UserID: newChirp.UserID,
+	err = cfg.saveChirpEntities(r.Context(), qtx, newChirp)
+	if err != nil {
+		log.Printf("Error saving entities for chirp %v: %s", newChirp.ID, err)
+		w.WriteHeader(500)
+		return
+	}
+	err = cfg.attachChirpMedia(r.Context(), qtx, tokenUserID, newChirp.ID, chirps.MediaIDs)
+	if errors.Is(err, errInvalidMedia) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		log.Printf("Error attaching media to chirp %v: %s", newChirp.ID, err)
+		w.WriteHeader(500)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("Error saving chirp %v: %s", newChirp.ID, err)
+		w.WriteHeader(500)
+		return
+	}
+	if filtered.Flagged {
+		err = cfg.flagChirp(r.Context(), newChirp.ID, filtered)
+		if err != nil {
+			log.Printf("Error flagging chirp %v for review: %s", newChirp.ID, err)
+		}
+	}
+	cs, err := cfg.buildChirpResponse(r.Context(), newChirp, tokenUserID)
+	if err != nil {
+		log.Printf("Error building chirp %v: %s", newChirp.ID, err)
+		w.WriteHeader(500)
+		return
 	}
 	dst, err := json.Marshal(cs)
 	if err != nil {
@@ -194,69 +331,118 @@ func (cfg *apiConfig) createChirp(w http.ResponseWriter, r *http.Request){
 
 }
 
+// getChirps always returns one page, defaultPageSize chirps unless the
+// limit query parameter asks for more, up to maxPageSize.
 func (cfg *apiConfig) getChirps(w http.ResponseWriter, r *http.Request){
-	
-	authorid := r.URL.Query().Get("author_id")
-	ascOrdesc := r.URL.Query().Get("sort")
-	
-
-	var allChirps []chirpSuccess
-	var results  []database.Chirp
-	var err error
-	if authorid != ""{
-		// log.Printf("Found aurhorid: %s", authorid)
-		authParsed,err := uuid.Parse(authorid)
+	page, err := parsePageRequest(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	var authorID uuid.NullUUID
+	if authorid := r.URL.Query().Get("author_id"); authorid != "" {
+		authParsed, err := uuid.Parse(authorid)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte("Error getting chirps"))
 			log.Printf("Could not parse %s: %s", authorid, err)
-			return 
-		}
-		if ascOrdesc != "desc"{
-			results, err = cfg.db.GetChirpsByAuthor(r.Context(),authParsed)
-		} else {
-			results, err = cfg.db.GetChirpsByAuthorDesc(r.Context(),authParsed)
-		}
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Error getting chirps"))
-			log.Printf("There was an error getting chirps: %s",err)
 			return
 		}
-		// log.Printf("results from authorid %s is %v",authorid, results)
-		
+		authorID = uuid.NullUUID{UUID: authParsed, Valid: true}
+	}
+	cursorCreatedAt, cursorID := page.cursorArgs()
+
+	// fetch one extra row to find out whether there is another page
+	desc := r.URL.Query().Get("sort") == "desc"
+	var results []database.Chirp
+	if !desc {
+		var rows []database.GetChirpsPageRow
+		rows, err = cfg.db.GetChirpsPage(r.Context(), database.GetChirpsPageParams{
+			AuthorID: authorID,
+			CursorCreatedAt: cursorCreatedAt,
+			CursorID: cursorID,
+			PageSize: int32(page.Limit + 1),
+		})
+		results = chirpsFromRows(rows)
 	} else {
-		if ascOrdesc != "desc"{
-		results, err = cfg.db.GetAllChirps(r.Context())
+		var rows []database.GetChirpsPageDescRow
+		rows, err = cfg.db.GetChirpsPageDesc(r.Context(), database.GetChirpsPageDescParams{
+			AuthorID: authorID,
+			CursorCreatedAt: cursorCreatedAt,
+			CursorID: cursorID,
+			PageSize: int32(page.Limit + 1),
+		})
+		results = chirpsFromRows(rows)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error getting chirps"))
+		log.Printf("There was an error getting chirps: %s",err)
+		return
+	}
+	items := chirpFeedItems(results)
+
+	// author listings include the author's rechirps, paged on the same keys
+	if authorID.Valid {
+		if !desc {
+			rechirps, err := cfg.db.GetRechirpsByUserPage(r.Context(), database.GetRechirpsByUserPageParams{
+				UserID: authorID.UUID,
+				CursorCreatedAt: cursorCreatedAt,
+				CursorID: cursorID,
+				PageSize: int32(page.Limit + 1),
+			})
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("Error getting chirps"))
+				log.Printf("There was an error getting rechirps: %s",err)
+				return
+			}
+			for _, val := range rechirps {
+				items = append(items, rechirpFeedItem(val.Rechirp, chirpFromColumns(chirpColumns{val.ID, val.CreatedAt, val.UpdatedAt, val.Body, val.UserID, val.InReplyTo, val.QuotedChirpID})))
+			}
 		} else {
-			results, err = cfg.db.GetAllChirpsDesc(r.Context())
-		}
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Error getting chirps"))
-			log.Printf("There was an error getting chirps: %s",err)
-			return
+			rechirps, err := cfg.db.GetRechirpsByUserPageDesc(r.Context(), database.GetRechirpsByUserPageDescParams{
+				UserID: authorID.UUID,
+				CursorCreatedAt: cursorCreatedAt,
+				CursorID: cursorID,
+				PageSize: int32(page.Limit + 1),
+			})
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("Error getting chirps"))
+				log.Printf("There was an error getting rechirps: %s",err)
+				return
+			}
+			for _, val := range rechirps {
+				items = append(items, rechirpFeedItem(val.Rechirp, chirpFromColumns(chirpColumns{val.ID, val.CreatedAt, val.UpdatedAt, val.Body, val.UserID, val.InReplyTo, val.QuotedChirpID})))
+			}
 		}
+		sortFeed(items, desc)
 	}
-	for _, val := range results{
-		newChirp := chirpSuccess{
-			ID: val.ID,
-			CreatedAt: val.CreatedAt,
-			UpdatedAt: val.UpdatedAt,
-			Body: val.Body,
-			UserID: val.UserID,
-		}
-		allChirps = append(allChirps, newChirp)
+
+	chirpsPage := chirpPage{}
+	if len(items) > page.Limit {
+		items = items[:page.Limit]
+		chirpsPage.NextCursor = encodeCursor(items[len(items)-1].cursor())
 	}
-	dst, err := json.Marshal(allChirps)
+	chirpsPage.Chirps, err = cfg.buildFeedResponses(r.Context(), items, cfg.optionalUserID(r))
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("Error getting db data %v: %s", dst, err)))
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error getting chirps"))
+		log.Printf("There was an error building chirps: %s",err)
+		return
+	}
+	dst, err := json.Marshal(chirpsPage)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error getting chirps"))
+		log.Printf("Error marshalling %v: %s", chirpsPage, err)
+		return
 	}
-	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type","application/json")
-	w.Write([]byte(dst))
-
+	w.WriteHeader(http.StatusOK)
+	w.Write(dst)
 }
 
 func (cfg *apiConfig) getOneChirp(w http.ResponseWriter, r *http.Request){
@@ -270,12 +456,12 @@ func (cfg *apiConfig) getOneChirp(w http.ResponseWriter, r *http.Request){
 		return 
 	}
 	
-	chirp = chirpSuccess{
-			ID: results.ID,
-			CreatedAt: results.CreatedAt,
-			UpdatedAt: results.UpdatedAt,
-			Body: results.Body,
-			UserID: results.UserID,
+	chirp, err = cfg.buildChirpResponse(r.Context(), results, cfg.optionalUserID(r))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error finding chirp"))
+		log.Printf("There was an error building chirp %v: %s", chirpID, err)
+		return
 	}
 	dst, err := json.Marshal(chirp)
 	if err != nil {
@@ -299,10 +485,7 @@ func (cfg *apiConfig) chirpLogin(w http.ResponseWriter, r *http.Request){
 		log.Printf("Error decoding the request %v: %s",r.Body,err)
 		return 
 	}
-	
-	chirpUser.ExpiresInSeconds = int(time.Second) * 3600
-	
-	// log.Println("The user expiration duration is set to ", chirpUser.ExpiresInSeconds)
+
 	userLookup, err := cfg.db.LookupUser(r.Context(), chirpUser.Email)
 	if err != nil {
 		log.Printf("Could not lookup user %s: %s",chirpUser.Email, err)
@@ -320,7 +503,23 @@ func (cfg *apiConfig) chirpLogin(w http.ResponseWriter, r *http.Request){
 		w.Write([]byte("Incorrect email or password"))
 		return 	
 	}
-	chirpUserToken, err := auth.MakeJWT(userLookup.ID, cfg.secret, time.Duration(chirpUser.ExpiresInSeconds))
+	if userLookup.DeletedAt.Valid {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Account is scheduled for deletion, restore it with POST /api/users/restore"))
+		return
+	}
+	if userLookup.TotpEnabledAt.Valid {
+		cfg.startLoginChallenge(w, r, userLookup)
+		return
+	}
+	cfg.issueSession(w, r, userLookup)
+}
+
+// issueSession responds with a new access token and refresh token for user,
+// the final step of every way of logging in.
+func (cfg *apiConfig) issueSession(w http.ResponseWriter, r *http.Request, userLookup database.User){
+	expiresIn := time.Hour
+	chirpUserToken, err := cfg.makeJWT(userLookup.ID, expiresIn)
 	
 	// log.Println("the token that was created is: ",chirpUserToken)
 	if err != nil {
@@ -337,17 +536,19 @@ func (cfg *apiConfig) chirpLogin(w http.ResponseWriter, r *http.Request){
 		return 
 		 
 	}
-	refreshTokenParams := database.InsertRefreshTokenParams{UserID: userLookup.ID, Token: chirpUserRefreshtoken}
-	_, err = cfg.db.InsertRefreshToken(r.Context(), refreshTokenParams)
+	// only the digest is stored, the client gets the token itself
+	userAgent, ip := clientInfo(r)
+	refreshTokenParams := database.InsertSessionRefreshTokenParams{UserID: userLookup.ID, Token: hashToken(chirpUserRefreshtoken), UserAgent: userAgent, IpAddress: ip}
+	_, err = cfg.db.InsertSessionRefreshToken(r.Context(), refreshTokenParams)
 	if err != nil {
-		log.Printf("Error inserting the refresh token %v: %s",refreshTokenParams, err )
+		log.Printf("Error inserting the refresh token for %v: %s",userLookup.ID, err )
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("Error with the server, please try again."))
 		return 
 	}
 	// log.Printf("Refresh token %v inserted successfully\n", rt)
 
-	finalUser := createDBUserResponse{ ID: userLookup.ID, CreatedAt: userLookup.CreatedAt, UpdatedAt: userLookup.UpdatedAt, Email: userLookup.Email, Token: chirpUserToken, RefreshToken: chirpUserRefreshtoken, IsChirpyRd: userLookup.IsChirpyRed}
+	finalUser := createDBUserResponse{ ID: userLookup.ID, CreatedAt: userLookup.CreatedAt, UpdatedAt: userLookup.UpdatedAt, Email: userLookup.Email, Token: chirpUserToken, RefreshToken: chirpUserRefreshtoken, IsChirpyRd: userLookup.IsChirpyRed, EmailVerified: userLookup.EmailVerifiedAt.Valid}
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type","application/json")
 	dst, err := json.Marshal(finalUser)
@@ -368,9 +569,9 @@ func (cfg *apiConfig) getRefreshToken(w http.ResponseWriter, r *http.Request){
 		w.Write([]byte("User must be logged in"))
 		return 
 	}
-	userFromToken, err := cfg.db.GetRefreshToken(r.Context(),headerToken)
+	userFromToken, err := cfg.db.GetRefreshToken(r.Context(),hashToken(headerToken))
 	if err != nil {
-		log.Printf("There was an error getting %s from the database: %s", headerToken, err)
+		log.Printf("There was an error getting the refresh token from the database: %s", err)
 		w.WriteHeader(http.StatusUnauthorized)
 		w.Write([]byte("Server Error, please try again."))
 		return 
@@ -384,7 +585,7 @@ func (cfg *apiConfig) getRefreshToken(w http.ResponseWriter, r *http.Request){
 		return
 
 	}
-	if userFromToken.ExpiresAt.Compare(time.Now().Add(time.Hour * 24 * 60)) == +1 {
+	if !userFromToken.ExpiresAt.After(time.Now()) {
 		log.Printf("Token has expired for user %v at %v", userFromToken.UserID, userFromToken.ExpiresAt)
 		w.WriteHeader(http.StatusUnauthorized)
 		w.Write([]byte("Token has expired"))
@@ -398,19 +599,54 @@ func (cfg *apiConfig) getRefreshToken(w http.ResponseWriter, r *http.Request){
 	// log.Printf("This is the ok from sql null time: %s",ok)
 	if ok != nil {
 		log.Printf("The token was revoked at: %v",userFromToken.RevokedAt)
+		if userFromToken.RotatedAt.Valid {
+			cfg.revokeStolenFamily(r.Context(), userFromToken)
+		}
 		w.WriteHeader(http.StatusUnauthorized)
 		w.Write([]byte("Invalid Token"))
 		return 
 	}
 
-	val, err := auth.MakeJWT(userFromToken.UserID, cfg.secret, time.Hour)
+	// every refresh hands out a new refresh token in the same family and
+	// retires the one presented
+	newRefreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		log.Printf("There was an error creating refresh token: %s\n",err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, Please try again"))
+		return 
+	}
+	userAgent, ip := clientInfo(r)
+	_, err = cfg.db.InsertRotatedRefreshToken(r.Context(), database.InsertRotatedRefreshTokenParams{Token: hashToken(newRefreshToken), UserID: userFromToken.UserID, FamilyID: userFromToken.FamilyID, UserAgent: userAgent, IpAddress: ip})
+	if err != nil {
+		log.Printf("Error inserting the refresh token for %v: %s", userFromToken.UserID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, Please try again"))
+		return 
+	}
+	rotated, err := cfg.db.RotateRefreshToken(r.Context(), userFromToken.Token)
+	if err != nil {
+		log.Printf("Error rotating the refresh token for %v: %s", userFromToken.UserID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, Please try again"))
+		return 
+	}
+	if rotated != 1 {
+		// another request used this token first
+		cfg.revokeStolenFamily(r.Context(), userFromToken)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Invalid Token"))
+		return 
+	}
+
+	val, err := cfg.makeJWT(userFromToken.UserID, time.Hour)
 	if err != nil {
 		log.Printf("Error generating JWT: %s", err)
 		w.WriteHeader(http.StatusUnauthorized)
 		w.Write([]byte("Server Error, Please try again"))
 		return 
 	}
-	newjwt := NewJWT{Token: val}
+	newjwt := NewJWT{Token: val, RefreshToken: newRefreshToken}
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type","application/json")
 	dst, err := json.Marshal(newjwt)
@@ -425,6 +661,17 @@ func (cfg *apiConfig) getRefreshToken(w http.ResponseWriter, r *http.Request){
 	
 }
 
+// revokeStolenFamily is called when a refresh token that was already rotated
+// is presented again. Either the client or an attacker holds a copy, and we
+// cannot tell which, so every token in the family stops working.
+func (cfg *apiConfig) revokeStolenFamily(ctx context.Context, token database.RefreshToken){
+	log.Printf("Refresh token reuse detected for user %v, revoking family %v", token.UserID, token.FamilyID)
+	err := cfg.db.RevokeRefreshTokenFamily(ctx, token.FamilyID)
+	if err != nil {
+		log.Printf("Error revoking refresh token family %v: %s", token.FamilyID, err)
+	}
+}
+
 func (cfg *apiConfig) revokeRefreshToken(w http.ResponseWriter, r *http.Request) {
 	tokenHeader, err := auth.GetBearerToken(r.Header)
 	if err != nil {
@@ -433,7 +680,7 @@ func (cfg *apiConfig) revokeRefreshToken(w http.ResponseWriter, r *http.Request)
 		w.Write([]byte("There was a server error, please try again."))
 		return		
 	}
-	userFromToken, err := cfg.db.GetRefreshToken(r.Context(),tokenHeader)
+	userFromToken, err := cfg.db.GetRefreshToken(r.Context(),hashToken(tokenHeader))
 	if err != nil {
 		log.Printf("Error getting user information from the database: %s",err)
 		w.WriteHeader(http.StatusBadRequest)
@@ -447,7 +694,8 @@ func (cfg *apiConfig) revokeRefreshToken(w http.ResponseWriter, r *http.Request)
 		w.Write([]byte("Bad Request to revoke this user."))
 		return 
 	}
-	err = cfg.db.SetRevokedDate(r.Context(), userFromToken.Token)
+	// logging out ends the whole session, not just its latest token
+	err = cfg.db.RevokeRefreshTokenFamily(r.Context(), userFromToken.FamilyID)
 	if err != nil {
 		log.Printf("There was an error removing %s from the database", userFromToken.UserID)
 		w.WriteHeader(http.StatusBadRequest)
@@ -459,47 +707,99 @@ func (cfg *apiConfig) revokeRefreshToken(w http.ResponseWriter, r *http.Request)
 
 }
 
+// updateUsers changes the password and/or email of the user the token
+// belongs to. Both need the current password. A new email only replaces the
+// old one once it has been verified.
 func (cfg *apiConfig) updateUsers(w http.ResponseWriter, r *http.Request){
-	tokenHeader, err := auth.GetBearerToken(r.Header)
+	userID := authUserID(r)
+	update := userUpdate{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&update)
 	if err != nil {
-		log.Printf("There was an error getting the header token")
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte("Server Error, please try again."))
-		return 
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Data Error"))
+		log.Printf("Error decoding the request %v: %s",r.Body,err)
+		return
 	}
-	_,err = auth.ValidateJWT(tokenHeader, cfg.secret) 
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
 	if err != nil {
-		log.Printf("Invalid token: %s", tokenHeader)
+		log.Printf("Error looking up user %v: %s", userID, err)
 		w.WriteHeader(http.StatusUnauthorized)
 		w.Write([]byte("Invalid User"))
-		return 
+		return
 	}
-	updateChirpUser := chirpUser{}
-	decoder := json.NewDecoder(r.Body)
-	err = decoder.Decode(&updateChirpUser)
-	if err != nil {
+	changeEmail := update.Email != "" && update.Email != user.Email
+	if update.Password == "" && !changeEmail {
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("There was an error logging in."))
-		log.Fatalf("Error decoding the request %v: %s",r.Body,err)
+		w.Write([]byte("Nothing to update"))
+		return
 	}
-	password, err := auth.HashPassword(updateChirpUser.Password)
-	if err != nil {
-		log.Printf("Error hashing password %s: %s", updateChirpUser.Password, err)
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Server error, please try again."))
-		return 
+	if update.CurrentPassword == "" {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Current password is required"))
+		return
 	}
-	params := database.UpdatePasswordParams{Email: updateChirpUser.Email, HashedPassword: password}
-	err = cfg.db.UpdatePassword(r.Context(), params)
+	err = auth.CheckPasswordHash(update.CurrentPassword, user.HashedPassword)
 	if err != nil {
-		log.Printf("There was an error updating user %s, erorr: %s", updateChirpUser.Email, err)
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte("Error updating the user"))
-		return 
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Incorrect password"))
+		return
+	}
+	if changeEmail {
+		if !validEmail(update.Email) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("A valid email address is required"))
+			return
+		}
+		_, err = cfg.db.LookupUser(r.Context(), update.Email)
+		if err == nil {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte("Email address is already in use"))
+			return
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error looking up user %s: %s", update.Email, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Server Error, please try again"))
+			return
+		}
+	}
+
+	if update.Password != "" {
+		password, err := auth.HashPassword(update.Password)
+		if err != nil {
+			log.Printf("Error hashing password: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Server error, please try again."))
+			return 
+		}
+		err = cfg.db.SetUserPassword(r.Context(), database.SetUserPasswordParams{ID: user.ID, HashedPassword: password})
+		if err != nil {
+			log.Printf("There was an error updating user %v, erorr: %s", user.ID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Error updating the user"))
+			return 
+		}
+	}
+	finalUser := createDBUserResponse{
+		ID: user.ID,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+		Email: user.Email,
+		IsChirpyRd: user.IsChirpyRed,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+	}
+	if changeEmail {
+		err = cfg.sendVerificationEmail(r.Context(), user.ID, update.Email)
+		if err != nil {
+			log.Printf("Error sending verification email to %v: %s", user.ID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Error sending verification email, please try again"))
+			return
+		}
+		cfg.notifyEmailChange(r.Context(), user.Email, update.Email)
+		finalUser.PendingEmail = update.Email
 	}
-	finalUser := chirpUser{Email: updateChirpUser.Email}
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type","application/json")
 	dst, err := json.Marshal(finalUser)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -507,26 +807,13 @@ func (cfg *apiConfig) updateUsers(w http.ResponseWriter, r *http.Request){
 		log.Printf("Error marshalling %v: %s", dst, err)
 		return 
 	}
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(dst))
-
-
-
 }
 
 func (cfg *apiConfig) deleteChirp(w http.ResponseWriter, r *http.Request){
-	tokenHeader, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		log.Printf("Error getting token")
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte("Server Error, please try again"))
-		return 
-	}
-	userID, err := auth.ValidateJWT(tokenHeader, cfg.secret)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte("Error validating user"))
-		return 
-	}
+	userID := authUserID(r)
 	
 	chirpID,_ := uuid.Parse(r.PathValue("chirpID"))
 	
@@ -540,6 +827,13 @@ func (cfg *apiConfig) deleteChirp(w http.ResponseWriter, r *http.Request){
 	if userID == results.UserID {
 	// log.Printf("The results from the lookup: %v", results)
 	//log.Printf("This is in the header: %v", r.Header)	
+		err = cfg.deleteChirpMedia(r.Context(), results.ID)
+		if err != nil {
+			log.Printf("There was an error deleting media of %v: %s", results.ID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Error Deleting the chirp"))
+			return
+		}
 		err = cfg.db.DeleteChirp(r.Context(), results.ID)
 		if err != nil {
 			log.Printf("There was an error deleting %v: %s", results.ID, err)
@@ -567,16 +861,159 @@ func (cfg *apiConfig) deleteChirp(w http.ResponseWriter, r *http.Request){
 
 }
 
-func cleanChirp(chirp string) string {
-	var final_string = strings.Split(chirp," ")
-	for idx,val := range final_string {
-		if strings.ToLower(val) == "kerfuffle" || strings.ToLower(val) == "sharbert" || strings.ToLower(val) == "fornax"{
-			final_string[idx] = "****"
-		}
+func (cfg *apiConfig) editChirp(w http.ResponseWriter, r *http.Request){
+	userID := authUserID(r)
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid chirp id"))
+		return
+	}
+	results, err := cfg.db.GetOneChirp(r.Context(), chirpID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Error finding chirp"))
+		log.Printf("There was an error getting chirp %v: %s", chirpID, err)
+		return
+	}
+	if userID != results.UserID {
+		log.Printf("User %s not authorized to edit %s", userID, results.ID)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Not authorized to edit chirp."))
+		return
+	}
+
+	chirps := incomingChirp{}
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&chirps)
+	if err != nil {
+		log.Printf("Error decoding chirp: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Something went wrong"))
+		return
+	}
+	if utf8.RuneCountInString(chirps.Body) > 140 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Chirp is too long"))
+		return
+	}
+	filtered := cfg.contentFilter.Check(chirps.Body)
+	if filtered.Rejected {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Chirp contains prohibited words"))
+		return
+	}
+	if filtered.Body == results.Body {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Chirp is unchanged"))
+		return
+	}
 
+	// the new body and its entities are saved together, so entity offsets
+	// always point into the body they were found in
+	tx, err := cfg.sqlDB.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error updating the chirp"))
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+	params := database.UpdateChirpBodyParams{ID: results.ID, Body: filtered.Body}
+	row, err := qtx.UpdateChirpBody(r.Context(), params)
+	if err != nil {
+		log.Printf("There was an error updating chirp %v: %s", results.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error updating the chirp"))
+		return
+	}
+	updated := chirpFromColumns(chirpColumns(row))
+	err = cfg.saveChirpEntities(r.Context(), qtx, updated)
+	if err != nil {
+		log.Printf("Error saving entities for chirp %v: %s", updated.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error updating the chirp"))
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("Error saving chirp %v: %s", updated.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error updating the chirp"))
+		return
+	}
+	if filtered.Flagged {
+		err = cfg.flagChirp(r.Context(), updated.ID, filtered)
+		if err != nil {
+			log.Printf("Error flagging chirp %v for review: %s", updated.ID, err)
+		}
+	}
+	cs, err := cfg.buildChirpResponse(r.Context(), updated, userID)
+	if err != nil {
+		log.Printf("Error building chirp %v: %s", updated.ID, err)
+		w.WriteHeader(500)
+		return
 	}
-	return strings.Join(final_string," ")
+	dst, err := json.Marshal(cs)
+	if err != nil {
+		log.Printf("Error marshalling json: %s",err)
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dst)
+}
 
+func (cfg *apiConfig) getChirpRevisions(w http.ResponseWriter, r *http.Request){
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid chirp id"))
+		return
+	}
+	_, err = cfg.db.GetOneChirp(r.Context(), chirpID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Error finding chirp"))
+		log.Printf("There was an error getting chirp %v: %s", chirpID, err)
+		return
+	}
+	results, err := cfg.db.GetChirpRevisions(r.Context(), chirpID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error getting revisions"))
+		log.Printf("There was an error getting revisions for %v: %s", chirpID, err)
+		return
+	}
+	revisions := []chirpRevision{}
+	for _, val := range results {
+		filtered := cfg.contentFilter.Check(val.Body)
+		revision := chirpRevision{
+			ID: val.ID,
+			ChirpID: val.ChirpID,
+			Body: filtered.Body,
+			CreatedAt: val.CreatedAt,
+			ReplacedAt: val.ReplacedAt,
+		}
+		if filtered.Rejected {
+			revision.Body = ""
+			revision.Withheld = true
+		}
+		revisions = append(revisions, revision)
+	}
+	dst, err := json.Marshal(revisions)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error getting revisions"))
+		log.Printf("Error marshalling %v: %s", revisions, err)
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dst)
 }
 
 func respondWithError(w http.ResponseWriter, code int, msg string){	
@@ -597,7 +1034,100 @@ godotenv.Load(".env")
 dbURL := os.Getenv("DB_URL")
 secretKey := os.Getenv("SECRET")
 polka_secret := os.Getenv("POLKA_KEY")
+admin_secret := os.Getenv("ADMIN_KEY")
+filterWords := os.Getenv("FILTER_WORDS")
+if filterWords == "" {
+	filterWords = defaultFilterWords
+}
+filterRules, err := filter.ParseRules(filterWords)
+if err != nil {
+	log.Fatalf("Invalid FILTER_WORDS: %s", err)
+}
+// uploads are served by the /app/ file server, so they must live under rootDir
+mediaDir := os.Getenv("MEDIA_DIR")
+if mediaDir == "" {
+	mediaDir = "assets/media"
+}
+mediaPath := "/" + filepath.ToSlash(filepath.Clean(mediaDir)) + "/"
+mediaStore, err := blobstore.NewLocal(mediaDir, "/app"+mediaPath)
+if err != nil {
+	log.Fatalf("Error creating media directory %s: %s", mediaDir, err)
+}
 
+mailFrom := os.Getenv("MAIL_FROM")
+if mailFrom == "" {
+	mailFrom = "no-reply@localhost"
+}
+mailDir := os.Getenv("MAIL_DIR")
+if mailDir == "" {
+	mailDir = "mail"
+}
+smtpConfig := mailer.SMTP{Host: os.Getenv("SMTP_HOST"), Port: os.Getenv("SMTP_PORT"), Username: os.Getenv("SMTP_USERNAME"), Password: os.Getenv("SMTP_PASSWORD"), From: mailFrom}
+mail, err := mailer.New(os.Getenv("MAILER"), smtpConfig, mailDir)
+if err != nil {
+	log.Fatalf("Error configuring MAILER: %s", err)
+}
+publicURL := strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/")
+if publicURL == "" {
+	publicURL = "http://localhost:8080"
+}
+requireVerifiedEmail := os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true"
+signingAlg := os.Getenv("JWT_SIGNING_ALG")
+if signingAlg == "" {
+	signingAlg = jwtkeys.EdDSA
+}
+if signingAlg != jwtkeys.EdDSA && signingAlg != jwtkeys.RS256 {
+	log.Fatalf("Invalid JWT_SIGNING_ALG %q, use %s or %s", signingAlg, jwtkeys.EdDSA, jwtkeys.RS256)
+}
+// HS256 tokens signed with SECRET are only accepted when asked for, to
+// carry sessions across the switch to signing keys
+legacySecret := ""
+if os.Getenv("JWT_ACCEPT_LEGACY_HS256") == "true" {
+	legacySecret = secretKey
+}
+keyRotation := defaultKeyRotation
+if rotation := os.Getenv("JWT_KEY_ROTATION"); rotation != "" {
+	keyRotation, err = time.ParseDuration(rotation)
+	if err != nil {
+		log.Fatalf("Invalid JWT_KEY_ROTATION: %s", err)
+	}
+}
+deletionGrace := defaultDeletionGrace
+if grace := os.Getenv("ACCOUNT_DELETION_GRACE"); grace != "" {
+	deletionGrace, err = time.ParseDuration(grace)
+	if err != nil {
+		log.Fatalf("Invalid ACCOUNT_DELETION_GRACE: %s", err)
+	}
+}
+exportDir := os.Getenv("EXPORT_DIR")
+if exportDir == "" {
+	exportDir = "exports"
+}
+exportStore, err := blobstore.NewLocal(exportDir, "")
+if err != nil {
+	log.Fatalf("Error creating export directory %s: %s", exportDir, err)
+}
+// OIDC_PROVIDERS names the external login providers, each configured with
+// OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID and OIDC_<NAME>_CLIENT_SECRET.
+oidcProviders := map[string]*oidc.Provider{}
+for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		continue
+	}
+	prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+	issuer := os.Getenv(prefix + "ISSUER")
+	clientID := os.Getenv(prefix + "CLIENT_ID")
+	if issuer == "" || clientID == "" {
+		log.Fatalf("%sISSUER and %sCLIENT_ID must be set for login provider %s", prefix, prefix, name)
+	}
+	oidcProviders[name] = oidc.New(oidc.Config{
+		Issuer: issuer,
+		ClientID: clientID,
+		ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+		RedirectURL: publicURL + "/api/login/oidc/" + name + "/callback",
+	})
+}
 
 db, err := sql.Open("postgres", dbURL)
 if err != nil {
@@ -608,7 +1138,18 @@ dbQueries := database.New(db)
 rootDir := "."
 httpPort := 8080
 
-apiConfig := apiConfig{fileserverHits: atomic.Int32{}, db: dbQueries, secret: secretKey, polka_key: polka_secret}
+wordList := filter.NewWordList(filterRules)
+apiConfig := apiConfig{fileserverHits: atomic.Int32{}, db: dbQueries, sqlDB: db, secret: secretKey, signingKeys: jwtkeys.NewSet(legacySecret), signing_alg: signingAlg, key_rotation: keyRotation, polka_key: polka_secret, admin_key: admin_secret, filterRules: filterRules, filterWords: wordList, contentFilter: filter.Pipeline{wordList}, media: mediaStore, mediaPath: mediaPath, exports: exportStore, mailer: mail, public_url: publicURL, require_verified_email: requireVerifiedEmail, deletion_grace: deletionGrace, now: time.Now, oidcProviders: oidcProviders}
+err = apiConfig.reloadFilterWords(context.Background())
+if err != nil {
+	log.Fatalf("Error loading filter words: %s", err)
+}
+err = apiConfig.rotateSigningKeys(context.Background())
+if err != nil {
+	log.Fatalf("Error loading signing keys: %s", err)
+}
+
+go apiConfig.runCleanup(context.Background(), time.Hour)
 
 mux := http.NewServeMux()
 
@@ -618,23 +1159,78 @@ server := &http.Server{
 }
 
 fs := http.FileServer(http.Dir(rootDir))
-mux.Handle("/app/", apiConfig.middlewareMetricsInc(http.StripPrefix("/app",apiConfig.addHeaders(fs))))
+privatePaths := []string{"/" + filepath.ToSlash(filepath.Clean(exportDir)) + "/", "/" + filepath.ToSlash(filepath.Clean(mailDir)) + "/"}
+mux.Handle("/app/", apiConfig.middlewareMetricsInc(http.StripPrefix("/app",apiConfig.addHeaders(hidePaths(privatePaths, fs)))))
 
 
 mux.HandleFunc("GET /admin/metrics",apiConfig.getMetrics)
 mux.HandleFunc("POST /admin/reset", apiConfig.reset)
+mux.HandleFunc("GET /admin/filter/words", apiConfig.getFilterWords)
+mux.HandleFunc("POST /admin/filter/words", apiConfig.putFilterWord)
+mux.HandleFunc("DELETE /admin/filter/words/{word}", apiConfig.deleteFilterWord)
+mux.HandleFunc("GET /admin/reviews", apiConfig.getPendingReviews)
+mux.HandleFunc("POST /admin/reviews/{chirpID}/resolve", apiConfig.resolveReview)
 
 mux.HandleFunc("GET /api/healthz", handleHealth)
+mux.HandleFunc("GET /.well-known/jwks.json", apiConfig.getJWKS)
 mux.HandleFunc("GET /api/chirps", apiConfig.getChirps)
 mux.HandleFunc("GET /api/chirps/{chirpID}", apiConfig.getOneChirp)
-mux.HandleFunc("POST /api/chirps", apiConfig.createChirp)
+mux.Handle("POST /api/chirps", apiConfig.middlewareAuth(scopeChirpsWrite, apiConfig.createChirp))
 mux.HandleFunc("POST /api/users", apiConfig.createUsers)
+mux.HandleFunc("POST /api/users/verify", apiConfig.verifyEmail)
+mux.Handle("POST /api/users/verify/resend", apiConfig.middlewareAuth(scopeAccountWrite, apiConfig.resendVerification))
 mux.HandleFunc("POST /api/login", apiConfig.chirpLogin)
+mux.HandleFunc("POST /api/login/2fa", apiConfig.completeLoginChallenge)
+mux.HandleFunc("GET /api/login/oidc", apiConfig.getOIDCProviders)
+mux.HandleFunc("GET /api/login/oidc/{provider}", apiConfig.startOIDCLogin)
+mux.HandleFunc("GET /api/login/oidc/{provider}/callback", apiConfig.finishOIDCLogin)
 mux.HandleFunc("POST /api/refresh", apiConfig.getRefreshToken)
 mux.HandleFunc("POST /api/revoke", apiConfig.revokeRefreshToken)
+mux.Handle("GET /api/sessions", apiConfig.middlewareAuth(scopeSession, apiConfig.getSessions))
+mux.Handle("DELETE /api/sessions", apiConfig.middlewareAuth(scopeSession, apiConfig.revokeAllSessions))
+mux.Handle("DELETE /api/sessions/{sessionID}", apiConfig.middlewareAuth(scopeSession, apiConfig.revokeSession))
+mux.Handle("POST /api/tokens", apiConfig.middlewareAuth(scopeSession, apiConfig.createToken))
+mux.Handle("GET /api/tokens", apiConfig.middlewareAuth(scopeSession, apiConfig.getTokens))
+mux.Handle("DELETE /api/tokens/{tokenID}", apiConfig.middlewareAuth(scopeSession, apiConfig.revokeToken))
+mux.Handle("POST /api/oauth/clients", apiConfig.middlewareAuth(scopeSession, apiConfig.createOAuthClient))
+mux.Handle("GET /api/oauth/clients", apiConfig.middlewareAuth(scopeSession, apiConfig.getOAuthClients))
+mux.Handle("DELETE /api/oauth/clients/{clientID}", apiConfig.middlewareAuth(scopeSession, apiConfig.deleteOAuthClient))
+mux.HandleFunc("GET /oauth/authorize", apiConfig.getAuthorize)
+mux.HandleFunc("POST /oauth/authorize", apiConfig.postAuthorize)
+mux.HandleFunc("POST /oauth/token", apiConfig.oauthToken)
+mux.HandleFunc("POST /oauth/revoke", apiConfig.oauthRevoke)
+mux.HandleFunc("POST /oauth/introspect", apiConfig.oauthIntrospect)
+mux.HandleFunc("POST /api/password/forgot", apiConfig.forgotPassword)
+mux.HandleFunc("POST /api/password/reset", apiConfig.resetPassword)
 
-mux.HandleFunc("PUT /api/users", apiConfig.updateUsers)
-mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiConfig.deleteChirp)
+mux.Handle("PUT /api/users", apiConfig.middlewareAuth(scopeAccountWrite, apiConfig.updateUsers))
+mux.Handle("DELETE /api/chirps/{chirpID}", apiConfig.middlewareAuth(scopeChirpsWrite, apiConfig.deleteChirp))
+mux.Handle("PATCH /api/chirps/{chirpID}", apiConfig.middlewareAuth(scopeChirpsWrite, apiConfig.editChirp))
+mux.HandleFunc("GET /api/chirps/{chirpID}/revisions", apiConfig.getChirpRevisions)
+mux.HandleFunc("GET /api/chirps/{chirpID}/thread", apiConfig.getChirpThread)
+mux.Handle("POST /api/chirps/{chirpID}/likes", apiConfig.middlewareAuth(scopeChirpsWrite, apiConfig.likeChirp))
+mux.Handle("DELETE /api/chirps/{chirpID}/likes", apiConfig.middlewareAuth(scopeChirpsWrite, apiConfig.unlikeChirp))
+mux.HandleFunc("GET /api/users/{userID}/likes", apiConfig.getUserLikes)
+mux.Handle("POST /api/chirps/{chirpID}/rechirp", apiConfig.middlewareAuth(scopeChirpsWrite, apiConfig.rechirp))
+mux.Handle("DELETE /api/chirps/{chirpID}/rechirp", apiConfig.middlewareAuth(scopeChirpsWrite, apiConfig.undoRechirp))
+mux.HandleFunc("GET /api/hashtags/{tag}/chirps", apiConfig.getHashtagChirps)
+mux.HandleFunc("GET /api/users/{userID}/mentions", apiConfig.getUserMentions)
+mux.HandleFunc("GET /api/search/chirps", apiConfig.searchChirps)
+mux.Handle("POST /api/media", apiConfig.middlewareAuth(scopeMediaWrite, apiConfig.uploadMedia))
+mux.HandleFunc("GET /api/users/{handleOrID}", apiConfig.getProfile)
+mux.Handle("PATCH /api/users/me", apiConfig.middlewareAuth(scopeProfileWrite, apiConfig.updateProfile))
+mux.Handle("DELETE /api/users/me", apiConfig.middlewareAuth(scopeAccountWrite, apiConfig.deleteAccount))
+mux.HandleFunc("POST /api/users/restore", apiConfig.restoreAccount)
+mux.Handle("POST /api/users/me/export", apiConfig.middlewareAuth(scopeAccountRead, apiConfig.requestExport))
+mux.Handle("POST /api/users/me/2fa", apiConfig.middlewareAuth(scopeSession, apiConfig.enrollTwoFactor))
+mux.Handle("POST /api/users/me/2fa/confirm", apiConfig.middlewareAuth(scopeSession, apiConfig.confirmTwoFactor))
+mux.Handle("DELETE /api/users/me/2fa", apiConfig.middlewareAuth(scopeSession, apiConfig.disableTwoFactor))
+mux.Handle("GET /api/users/me/export/{exportID}", apiConfig.middlewareAuth(scopeAccountRead, apiConfig.getExport))
+mux.Handle("POST /api/users/{userID}/follow", apiConfig.middlewareAuth(scopeFollowsWrite, apiConfig.followUser))
+mux.Handle("DELETE /api/users/{userID}/follow", apiConfig.middlewareAuth(scopeFollowsWrite, apiConfig.unfollowUser))
+mux.HandleFunc("GET /api/users/{userID}/followers", apiConfig.getFollowers)
+mux.HandleFunc("GET /api/users/{userID}/following", apiConfig.getFollowing)
+mux.Handle("GET /api/timeline", apiConfig.middlewareAuth(scopeChirpsRead, apiConfig.getTimeline))
 mux.HandleFunc("POST /api/polka/webhooks", apiConfig.upgradeChirpyUser)
 
 
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/xsynch/chirpy/internal/database"
+	"github.com/xsynch/chirpy/internal/imageproc"
+)
+
+const maxMediaSize = 5 << 20
+const maxMediaPerChirp = 4
+
+// allowedMediaTypes is checked against the sniffed content type of an
+// upload. The declared type from the client is ignored.
+var allowedMediaTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png": true,
+	"image/gif": true,
+}
+
+func (cfg *apiConfig) uploadMedia(w http.ResponseWriter, r *http.Request){
+	userID := authUserID(r)
+
+	// leave some room for the multipart framing around the file itself
+	r.Body = http.MaxBytesReader(w, r.Body, maxMediaSize+(64<<10))
+	err := r.ParseMultipartForm(1 << 20)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			w.Write([]byte("File is too large"))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Expected a multipart upload"))
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Missing file"))
+		return
+	}
+	defer file.Close()
+	if header.Size > maxMediaSize {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte("File is too large"))
+		return
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Could not read file"))
+		return
+	}
+	contentType := http.DetectContentType(data)
+	if !allowedMediaTypes[contentType] {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		w.Write([]byte("Unsupported media type"))
+		return
+	}
+	// the original is re-encoded as well, which drops EXIF and GPS metadata
+	processed, err := imageproc.Process(data, contentType, imageproc.DefaultVariants)
+	if errors.Is(err, imageproc.ErrTooLarge) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte("Image dimensions are too large"))
+		return
+	}
+	if err != nil {
+		log.Printf("Error processing upload from %v: %s", userID, err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Could not read image"))
+		return
+	}
+
+	mediaID := uuid.New()
+	storageKey := mediaID.String() + processed.Original.Ext
+	storedKeys := []string{}
+	cleanup := func() {
+		for _, key := range storedKeys {
+			cfg.media.Delete(r.Context(), key)
+		}
+	}
+	err = cfg.media.Put(r.Context(), storageKey, bytes.NewReader(processed.Original.Data))
+	if err != nil {
+		log.Printf("Error storing media %s: %s", storageKey, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	storedKeys = append(storedKeys, storageKey)
+	params := database.InsertMediaParams{
+		ID: mediaID,
+		UserID: userID,
+		StorageKey: storageKey,
+		ContentType: processed.Original.ContentType,
+		SizeBytes: int64(len(processed.Original.Data)),
+		Width: int32(processed.Original.Width),
+		Height: int32(processed.Original.Height),
+	}
+	stored, err := cfg.db.InsertMedia(r.Context(), params)
+	if err != nil {
+		log.Printf("Error inserting media %v: %s", params, err)
+		cleanup()
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+
+	variants := []database.MediaVariant{}
+	for _, spec := range imageproc.DefaultVariants {
+		variant := processed.Variants[spec.Name]
+		variantKey := mediaID.String() + "_" + spec.Name + variant.Ext
+		err = cfg.media.Put(r.Context(), variantKey, bytes.NewReader(variant.Data))
+		if err != nil {
+			log.Printf("Error storing media %s: %s", variantKey, err)
+			cleanup()
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Server Error, please try again"))
+			return
+		}
+		storedKeys = append(storedKeys, variantKey)
+		variantParams := database.InsertMediaVariantParams{
+			MediaID: mediaID,
+			Name: spec.Name,
+			StorageKey: variantKey,
+			ContentType: variant.ContentType,
+			Width: int32(variant.Width),
+			Height: int32(variant.Height),
+		}
+		err = cfg.db.InsertMediaVariant(r.Context(), variantParams)
+		if err != nil {
+			log.Printf("Error inserting media variant %v: %s", variantParams, err)
+			cleanup()
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Server Error, please try again"))
+			return
+		}
+		variants = append(variants, database.MediaVariant(variantParams))
+	}
+
+	dst, err := json.Marshal(cfg.mediaResponse(stored, variants))
+	if err != nil {
+		log.Printf("Error marshalling %v: %s", stored, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(dst)
+}
+
+func (cfg *apiConfig) mediaResponse(m database.Medium, variants []database.MediaVariant) chirpMedia {
+	media := chirpMedia{
+		ID: m.ID,
+		URL: cfg.media.URL(m.StorageKey),
+		ContentType: m.ContentType,
+		Width: int(m.Width),
+		Height: int(m.Height),
+		Variants: map[string]mediaVariant{},
+	}
+	for _, val := range variants {
+		if val.MediaID != m.ID {
+			continue
+		}
+		media.Variants[val.Name] = mediaVariant{
+			URL: cfg.media.URL(val.StorageKey),
+			ContentType: val.ContentType,
+			Width: int(val.Width),
+			Height: int(val.Height),
+		}
+	}
+	return media
+}
+
+// isMediaPath reports whether a request to the /app/ file server is for an
+// uploaded file. Uploads are stored under fresh keys and never rewritten, so
+// they can be cached indefinitely.
+func (cfg *apiConfig) isMediaPath(path string) bool {
+	return cfg.mediaPath != "" && strings.HasPrefix(path, cfg.mediaPath)
+}
+
+var errInvalidMedia = errors.New("media must be uploaded by you and not attached to another chirp")
+
+// checkChirpMedia verifies that the media a new chirp references belong to
+// the author and have not been used yet.
+func (cfg *apiConfig) checkChirpMedia(ctx context.Context, userID uuid.UUID, mediaIDs []uuid.UUID) error{
+	if len(mediaIDs) == 0 {
+		return nil
+	}
+	if len(mediaIDs) > maxMediaPerChirp {
+		return errors.New("a chirp can have at most 4 media attachments")
+	}
+	seen := map[uuid.UUID]bool{}
+	for _, val := range mediaIDs {
+		if seen[val] {
+			return errors.New("duplicate media id")
+		}
+		seen[val] = true
+	}
+	media, err := cfg.db.GetMediaByIDs(ctx, mediaIDs)
+	if err != nil {
+		return err
+	}
+	if len(media) != len(mediaIDs) {
+		return errInvalidMedia
+	}
+	for _, val := range media {
+		if val.UserID != userID || val.ChirpID.Valid {
+			return errInvalidMedia
+		}
+	}
+	return nil
+}
+
+// attachChirpMedia attaches the media to a chirp inside the transaction q
+// that created it. checkChirpMedia has already looked at them, but another
+// chirp may have claimed one since, so every update must hit exactly one row.
+func (cfg *apiConfig) attachChirpMedia(ctx context.Context, q *database.Queries, userID uuid.UUID, chirpID uuid.UUID, mediaIDs []uuid.UUID) error{
+	for idx, val := range mediaIDs {
+		params := database.AttachMediaToChirpParams{
+			ID: val,
+			UserID: userID,
+			ChirpID: uuid.NullUUID{UUID: chirpID, Valid: true},
+			Position: sql.NullInt32{Int32: int32(idx), Valid: true},
+		}
+		attached, err := q.AttachMediaToChirp(ctx, params)
+		if err != nil {
+			return err
+		}
+		if attached != 1 {
+			return errInvalidMedia
+		}
+	}
+	return nil
+}
+
+// deleteChirpMedia deletes the files attached to a chirp. The media rows go
+// with the chirp itself, so the files go first and a failure leaves the
+// chirp in place to delete again.
+func (cfg *apiConfig) deleteChirpMedia(ctx context.Context, chirpID uuid.UUID) error{
+	keys, err := cfg.db.GetChirpStorageKeys(ctx, uuid.NullUUID{UUID: chirpID, Valid: true})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		err = cfg.media.Delete(ctx, key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeAbandonedMedia deletes uploads that were not attached to a chirp or
+// made an avatar within a day. The row is deleted before the files, so an
+// upload that is attached while this runs keeps them.
+func (cfg *apiConfig) purgeAbandonedMedia(ctx context.Context) error{
+	media, err := cfg.db.GetAbandonedMedia(ctx)
+	if err != nil || len(media) == 0 {
+		return err
+	}
+	ids := make([]uuid.UUID, 0, len(media))
+	for _, val := range media {
+		ids = append(ids, val.ID)
+	}
+	variants, err := cfg.db.GetMediaVariants(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for _, val := range media {
+		deleted, err := cfg.db.DeleteAbandonedMedium(ctx, val.ID)
+		if err != nil {
+			log.Printf("Error deleting abandoned media %v: %s", val.ID, err)
+			continue
+		}
+		if deleted == 0 {
+			continue
+		}
+		keys := []string{val.StorageKey}
+		for _, variant := range variants {
+			if variant.MediaID == val.ID {
+				keys = append(keys, variant.StorageKey)
+			}
+		}
+		for _, key := range keys {
+			err = cfg.media.Delete(ctx, key)
+			if err != nil {
+				log.Printf("Error deleting file %s of abandoned media %v: %s", key, val.ID, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/xsynch/chirpy/internal/blobstore"
+	"github.com/xsynch/chirpy/internal/database"
+)
+
+func TestPurgeAbandonedMediaKeepsAvatars(t *testing.T) {
+	cfg, _ := testConfig(t)
+	ctx := context.Background()
+	store, err := blobstore.NewLocal(t.TempDir(), "/app/media/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.media = store
+	user, err := cfg.db.CreateUser(ctx, database.CreateUserParams{Email: uuid.NewString() + "@example.com", HashedPassword: "unused"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// upload stores a file and a media row created two days ago
+	upload := func() database.Medium {
+		t.Helper()
+		id := uuid.New()
+		key := id.String() + ".png"
+		err := store.Put(ctx, key, strings.NewReader("png"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		medium, err := cfg.db.InsertMedia(ctx, database.InsertMediaParams{ID: id, UserID: user.ID, StorageKey: key, ContentType: "image/png", SizeBytes: 3, Width: 1, Height: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = cfg.sqlDB.ExecContext(ctx, "UPDATE media SET created_at = NOW() - INTERVAL '2 days' WHERE id = $1", id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return medium
+	}
+	avatar := upload()
+	abandoned := upload()
+	_, err = cfg.db.UpdateUserProfile(ctx, database.UpdateUserProfileParams{ID: user.ID, AvatarMediaID: uuid.NullUUID{UUID: avatar.ID, Valid: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cfg.purgeAbandonedMedia(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := store.Open(ctx, avatar.StorageKey)
+	if err != nil {
+		t.Errorf("avatar file was deleted: %v", err)
+	} else {
+		f.Close()
+	}
+	if got := reloadUser(t, cfg, user.ID).AvatarMediaID; got.UUID != avatar.ID {
+		t.Errorf("avatar_media_id = %v, want %v", got, avatar.ID)
+	}
+	if _, err := store.Open(ctx, abandoned.StorageKey); !errors.Is(err, blobstore.ErrNotFound) {
+		t.Errorf("abandoned file open error = %v, want ErrNotFound", err)
+	}
+	media, err := cfg.db.GetMediaByIDs(ctx, []uuid.UUID{avatar.ID, abandoned.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(media) != 1 || media[0].ID != avatar.ID {
+		t.Errorf("media rows left = %v, want only the avatar", media)
+	}
+}
@@ -0,0 +1,784 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xsynch/chirpy/internal/auth"
+	"github.com/xsynch/chirpy/internal/database"
+	"github.com/xsynch/chirpy/internal/totp"
+)
+
+// OAuth tokens are opaque and stored hashed like personal access tokens.
+// The prefixes tell authenticate which table to look in.
+const (
+	oauthAccessPrefix = "chirpy_oat_"
+	oauthRefreshPrefix = "chirpy_ort_"
+)
+
+const (
+	oauthCodeLifetime = 10 * time.Minute
+	oauthAccessLifetime = time.Hour
+	oauthRefreshLifetime = 60 * 24 * time.Hour
+	maxClientNameLength = 100
+	maxRedirectURIs = 10
+)
+
+var errInvalidClient = errors.New("invalid client")
+
+var scopeDescriptions = map[string]string{
+	scopeChirpsRead: "Read chirps and your timeline",
+	scopeChirpsWrite: "Post, edit, delete, like and rechirp chirps as you",
+	scopeMediaWrite: "Upload images",
+	scopeFollowsWrite: "Follow and unfollow accounts as you",
+	scopeProfileWrite: "Edit your profile",
+	scopeAccountRead: "Download an export of your account",
+	scopeAccountWrite: "Change your email address and password, or delete your account",
+}
+
+// validRedirectURI allows https URIs, http only on loopback for desktop
+// apps, and private-use schemes such as com.example.app:/callback for
+// mobile apps (RFC 8252).
+func validRedirectURI(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Fragment != "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return u.Host != ""
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	default:
+		return strings.Contains(u.Scheme, ".")
+	}
+}
+
+func oauthClientResponse(client database.OauthClient) oauthClient {
+	return oauthClient{
+		ClientID: client.ID,
+		Name: client.Name,
+		RedirectURIs: client.RedirectUris,
+		Confidential: client.SecretHash.Valid,
+		CreatedAt: client.CreatedAt,
+	}
+}
+
+// createOAuthClient registers a third-party app owned by the caller. A
+// confidential client's secret is only ever shown in this response.
+func (cfg *apiConfig) createOAuthClient(w http.ResponseWriter, r *http.Request){
+	userID := authUserID(r)
+	request := struct {
+		Name string `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Confidential bool `json:"confidential"`
+	}{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&request)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Data Error"))
+		return
+	}
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" || len(request.Name) > maxClientNameLength {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Clients need a name of up to 100 characters"))
+		return
+	}
+	if len(request.RedirectURIs) == 0 || len(request.RedirectURIs) > maxRedirectURIs {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Clients need between 1 and 10 redirect URIs"))
+		return
+	}
+	for _, uri := range request.RedirectURIs {
+		if !validRedirectURI(uri) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid redirect URI " + uri + ", use https, http on localhost or a private-use scheme"))
+			return
+		}
+	}
+	params := database.InsertOAuthClientParams{
+		ID: uuid.New(),
+		UserID: userID,
+		Name: request.Name,
+		RedirectUris: slices.Compact(request.RedirectURIs),
+	}
+	secret := ""
+	if request.Confidential {
+		token, tokenHash, err := newOneTimeToken()
+		if err != nil {
+			log.Printf("Error creating client secret: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Server Error, please try again"))
+			return
+		}
+		secret = token
+		params.SecretHash = sql.NullString{String: tokenHash, Valid: true}
+	}
+	client, err := cfg.db.InsertOAuthClient(r.Context(), params)
+	if err != nil {
+		log.Printf("Error saving client for %v: %s", userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	response := oauthClientResponse(client)
+	response.ClientSecret = secret
+	dst, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling client: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(dst)
+}
+
+func (cfg *apiConfig) getOAuthClients(w http.ResponseWriter, r *http.Request){
+	userID := authUserID(r)
+	results, err := cfg.db.GetUserOAuthClients(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error getting clients for %v: %s", userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	clients := make([]oauthClient, 0, len(results))
+	for _, val := range results {
+		clients = append(clients, oauthClientResponse(val))
+	}
+	dst, err := json.Marshal(clients)
+	if err != nil {
+		log.Printf("Error marshalling clients: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dst)
+}
+
+// deleteOAuthClient removes a client along with every code and token it
+// was issued.
+func (cfg *apiConfig) deleteOAuthClient(w http.ResponseWriter, r *http.Request){
+	userID := authUserID(r)
+	clientID, err := uuid.Parse(r.PathValue("clientID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid client id"))
+		return
+	}
+	deleted, err := cfg.db.DeleteOAuthClient(r.Context(), database.DeleteOAuthClientParams{ID: clientID, UserID: userID})
+	if err != nil {
+		log.Printf("Error deleting client %v: %s", clientID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	if deleted == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Client not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizeRequest is a validated /oauth/authorize request.
+type authorizeRequest struct {
+	Client database.OauthClient
+	RedirectURI string
+	State string
+	Scopes []string
+	CodeChallenge string
+}
+
+// authorizeError is an error in an /oauth/authorize request. Until the
+// client and redirect URI are known to be good the error is shown to the
+// user, afterwards it is sent back to the client.
+type authorizeError struct {
+	Code string
+	Description string
+	Redirect bool
+}
+
+// validPKCEValue checks the length and alphabet RFC 7636 requires of code
+// verifiers, which S256 challenges also satisfy.
+func validPKCEValue(value string) bool {
+	if len(value) < 43 || len(value) > 128 {
+		return false
+	}
+	for _, c := range value {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.ContainsRune("-._~", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (cfg *apiConfig) parseAuthorizeRequest(ctx context.Context, values url.Values) (authorizeRequest, *authorizeError){
+	request := authorizeRequest{State: values.Get("state")}
+	clientID, err := uuid.Parse(values.Get("client_id"))
+	if err != nil {
+		return request, &authorizeError{Code: "invalid_request", Description: "Missing or invalid client_id"}
+	}
+	request.Client, err = cfg.db.GetOAuthClient(ctx, clientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return request, &authorizeError{Code: "invalid_client", Description: "Unknown client"}
+	}
+	if err != nil {
+		log.Printf("Error getting client %v: %s", clientID, err)
+		return request, &authorizeError{Code: "server_error", Description: "Server Error, please try again"}
+	}
+	request.RedirectURI = values.Get("redirect_uri")
+	if request.RedirectURI == "" && len(request.Client.RedirectUris) == 1 {
+		request.RedirectURI = request.Client.RedirectUris[0]
+	}
+	if !slices.Contains(request.Client.RedirectUris, request.RedirectURI) {
+		return request, &authorizeError{Code: "invalid_request", Description: "redirect_uri is not registered for this client"}
+	}
+
+	if values.Get("response_type") != "code" {
+		return request, &authorizeError{Code: "unsupported_response_type", Description: "Only response_type=code is supported", Redirect: true}
+	}
+	if values.Get("code_challenge_method") != "S256" {
+		return request, &authorizeError{Code: "invalid_request", Description: "PKCE with code_challenge_method=S256 is required", Redirect: true}
+	}
+	request.CodeChallenge = values.Get("code_challenge")
+	if !validPKCEValue(request.CodeChallenge) {
+		return request, &authorizeError{Code: "invalid_request", Description: "Invalid code_challenge", Redirect: true}
+	}
+	request.Scopes = strings.Fields(values.Get("scope"))
+	if len(request.Scopes) == 0 {
+		return request, &authorizeError{Code: "invalid_scope", Description: "At least one scope is required", Redirect: true}
+	}
+	for _, scope := range request.Scopes {
+		if !slices.Contains(grantableScopes, scope) {
+			return request, &authorizeError{Code: "invalid_scope", Description: "Unknown scope " + scope, Redirect: true}
+		}
+	}
+	slices.Sort(request.Scopes)
+	request.Scopes = slices.Compact(request.Scopes)
+	return request, nil
+}
+
+// redirectWith adds params to the query of a registered redirect URI,
+// keeping any query it already has.
+func redirectWith(uri string, params url.Values) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	query := u.Query()
+	for key, val := range params {
+		query[key] = val
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func (cfg *apiConfig) redirectAuthorizeError(w http.ResponseWriter, r *http.Request, request authorizeRequest, authErr *authorizeError){
+	params := url.Values{"error": {authErr.Code}, "error_description": {authErr.Description}}
+	if request.State != "" {
+		params.Set("state", request.State)
+	}
+	http.Redirect(w, r, redirectWith(request.RedirectURI, params), http.StatusFound)
+}
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Authorize {{.ClientName}} - Chirpy</title>
+<style>
+body { font-family: sans-serif; max-width: 26rem; margin: 3rem auto; padding: 0 1rem; }
+label, input { display: block; width: 100%; box-sizing: border-box; }
+input { margin: 0.25rem 0 1rem; padding: 0.5rem; }
+.error { color: #b00020; }
+</style>
+</head>
+<body>
+{{if .Fatal}}
+<h1>Authorization failed</h1>
+<p class="error">{{.Error}}</p>
+{{else}}
+<h1>Authorize {{.ClientName}}</h1>
+<p>{{.ClientName}} wants to use your Chirpy account to:</p>
+<ul>
+{{range .Scopes}}<li>{{.}}</li>
+{{end}}</ul>
+<p>You will be sent back to {{.RedirectURI}}. {{.ClientName}} will not see your password.</p>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="post" action="/oauth/authorize">
+{{range $key, $val := .Params}}<input type="hidden" name="{{$key}}" value="{{$val}}">
+{{end}}<label for="email">Email</label>
+<input id="email" name="email" type="email" autocomplete="username" required>
+<label for="password">Password</label>
+<input id="password" name="password" type="password" autocomplete="current-password" required>
+<label for="code">Two-factor or recovery code, if enabled</label>
+<input id="code" name="code" autocomplete="one-time-code">
+<button type="submit" name="decision" value="approve">Allow</button>
+<button type="submit" name="decision" value="deny" formnovalidate>Deny</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+// authorizeParams are the request parameters the consent form posts back.
+var authorizeParams = []string{"response_type", "client_id", "redirect_uri", "scope", "state", "code_challenge", "code_challenge_method"}
+
+func renderConsent(w http.ResponseWriter, status int, values url.Values, request authorizeRequest, message string, fatal bool){
+	params := map[string]string{}
+	for _, key := range authorizeParams {
+		if values.Has(key) {
+			params[key] = values.Get(key)
+		}
+	}
+	scopes := make([]string, 0, len(request.Scopes))
+	for _, scope := range request.Scopes {
+		scopes = append(scopes, scopeDescriptions[scope])
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(status)
+	err := consentTemplate.Execute(w, struct {
+		ClientName string
+		RedirectURI string
+		Scopes []string
+		Params map[string]string
+		Error string
+		Fatal bool
+	}{request.Client.Name, request.RedirectURI, scopes, params, message, fatal})
+	if err != nil {
+		log.Printf("Error rendering consent page: %s", err)
+	}
+}
+
+// getAuthorize shows the consent screen, where the user logs in and
+// approves or denies the client.
+func (cfg *apiConfig) getAuthorize(w http.ResponseWriter, r *http.Request){
+	values := r.URL.Query()
+	request, authErr := cfg.parseAuthorizeRequest(r.Context(), values)
+	if authErr != nil && authErr.Redirect {
+		cfg.redirectAuthorizeError(w, r, request, authErr)
+		return
+	}
+	if authErr != nil {
+		renderConsent(w, http.StatusBadRequest, values, request, authErr.Description, true)
+		return
+	}
+	renderConsent(w, http.StatusOK, values, request, "", false)
+}
+
+// postAuthorize handles the consent form. The user's credentials are
+// checked here rather than with a bearer token because the form is the
+// only thing a browser sent to /oauth/authorize has.
+func (cfg *apiConfig) postAuthorize(w http.ResponseWriter, r *http.Request){
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Data Error"))
+		return
+	}
+	values := r.PostForm
+	request, authErr := cfg.parseAuthorizeRequest(r.Context(), values)
+	if authErr != nil && authErr.Redirect {
+		cfg.redirectAuthorizeError(w, r, request, authErr)
+		return
+	}
+	if authErr != nil {
+		renderConsent(w, http.StatusBadRequest, values, request, authErr.Description, true)
+		return
+	}
+	if values.Get("decision") != "approve" {
+		cfg.redirectAuthorizeError(w, r, request, &authorizeError{Code: "access_denied", Description: "The user denied the request"})
+		return
+	}
+
+	user, err := cfg.db.LookupUser(r.Context(), values.Get("email"))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error looking up user for client %v: %s", request.Client.ID, err)
+		renderConsent(w, http.StatusInternalServerError, values, request, "Server Error, please try again", false)
+		return
+	}
+	if err != nil || auth.CheckPasswordHash(values.Get("password"), user.HashedPassword) != nil {
+		renderConsent(w, http.StatusUnauthorized, values, request, "Incorrect email or password", false)
+		return
+	}
+	if user.DeletedAt.Valid {
+		renderConsent(w, http.StatusForbidden, values, request, "Account is scheduled for deletion", false)
+		return
+	}
+	if user.TotpEnabledAt.Valid {
+		code, recoveryCode := strings.TrimSpace(values.Get("code")), ""
+		if len(code) != totp.Digits {
+			code, recoveryCode = "", code
+		}
+		ok, err := cfg.checkSecondFactor(r.Context(), user, code, recoveryCode)
+		if errors.Is(err, errTwoFactorLocked) {
+			renderConsent(w, http.StatusTooManyRequests, values, request, "Too many incorrect codes, try again later", false)
+			return
+		}
+		if err != nil {
+			log.Printf("Error checking second factor for %v: %s", user.ID, err)
+			renderConsent(w, http.StatusInternalServerError, values, request, "Server Error, please try again", false)
+			return
+		}
+		if !ok {
+			renderConsent(w, http.StatusUnauthorized, values, request, "Enter a valid two-factor or recovery code", false)
+			return
+		}
+	}
+
+	code, codeHash, err := newOneTimeToken()
+	if err == nil {
+		err = cfg.db.InsertOAuthCode(r.Context(), database.InsertOAuthCodeParams{
+			CodeHash: codeHash,
+			GrantID: uuid.New(),
+			ClientID: request.Client.ID,
+			UserID: user.ID,
+			RedirectUri: request.RedirectURI,
+			Scopes: request.Scopes,
+			CodeChallenge: request.CodeChallenge,
+			LifetimeSeconds: int64(oauthCodeLifetime.Seconds()),
+		})
+	}
+	if err != nil {
+		log.Printf("Error creating authorization code for %v: %s", user.ID, err)
+		renderConsent(w, http.StatusInternalServerError, values, request, "Server Error, please try again", false)
+		return
+	}
+	params := url.Values{"code": {code}}
+	if request.State != "" {
+		params.Set("state", request.State)
+	}
+	http.Redirect(w, r, redirectWith(request.RedirectURI, params), http.StatusFound)
+}
+
+// writeOAuthError responds with an RFC 6749 section 5.2 error.
+func writeOAuthError(w http.ResponseWriter, status int, code string, description string){
+	dst, _ := json.Marshal(map[string]string{"error": code, "error_description": description})
+	w.Header().Set("Content-Type","application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(dst)
+}
+
+func writeOAuthJSON(w http.ResponseWriter, response any){
+	dst, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling oauth response: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dst)
+}
+
+// authenticateClient identifies the client calling the token, revocation
+// or introspection endpoint, with HTTP Basic or form credentials.
+// Confidential clients must present their secret, public clients only
+// their id.
+func (cfg *apiConfig) authenticateClient(r *http.Request) (database.OauthClient, error){
+	id, secret, basic := r.BasicAuth()
+	if !basic {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	clientID, err := uuid.Parse(id)
+	if err != nil {
+		return database.OauthClient{}, errInvalidClient
+	}
+	client, err := cfg.db.GetOAuthClient(r.Context(), clientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.OauthClient{}, errInvalidClient
+	}
+	if err != nil {
+		return database.OauthClient{}, err
+	}
+	if client.SecretHash.Valid {
+		if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(client.SecretHash.String)) != 1 {
+			return database.OauthClient{}, errInvalidClient
+		}
+	} else if secret != "" {
+		return database.OauthClient{}, errInvalidClient
+	}
+	return client, nil
+}
+
+// clientRequest parses a form request to one of the client endpoints and
+// authenticates the client, writing the error response if that fails.
+func (cfg *apiConfig) clientRequest(w http.ResponseWriter, r *http.Request) (database.OauthClient, bool){
+	err := r.ParseForm()
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "Request must be form encoded")
+		return database.OauthClient{}, false
+	}
+	client, err := cfg.authenticateClient(r)
+	if errors.Is(err, errInvalidClient) {
+		if _, _, basic := r.BasicAuth(); basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+		}
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return database.OauthClient{}, false
+	}
+	if err != nil {
+		log.Printf("Error authenticating oauth client: %s", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Server Error, please try again")
+		return database.OauthClient{}, false
+	}
+	return client, true
+}
+
+// issueOAuthTokens creates an access token and a refresh token for a grant.
+func (cfg *apiConfig) issueOAuthTokens(ctx context.Context, grantID uuid.UUID, clientID uuid.UUID, userID uuid.UUID, scopes []string) (oauthTokenResponse, error){
+	accessToken, _, err := newOneTimeToken()
+	if err != nil {
+		return oauthTokenResponse{}, err
+	}
+	refreshToken, _, err := newOneTimeToken()
+	if err != nil {
+		return oauthTokenResponse{}, err
+	}
+	accessToken, refreshToken = oauthAccessPrefix+accessToken, oauthRefreshPrefix+refreshToken
+	err = cfg.db.InsertOAuthToken(ctx, database.InsertOAuthTokenParams{
+		TokenHash: hashToken(accessToken),
+		GrantID: grantID,
+		Kind: "access",
+		ClientID: clientID,
+		UserID: userID,
+		Scopes: scopes,
+		LifetimeSeconds: int64(oauthAccessLifetime.Seconds()),
+	})
+	if err != nil {
+		return oauthTokenResponse{}, err
+	}
+	err = cfg.db.InsertOAuthToken(ctx, database.InsertOAuthTokenParams{
+		TokenHash: hashToken(refreshToken),
+		GrantID: grantID,
+		Kind: "refresh",
+		ClientID: clientID,
+		UserID: userID,
+		Scopes: scopes,
+		LifetimeSeconds: int64(oauthRefreshLifetime.Seconds()),
+	})
+	if err != nil {
+		return oauthTokenResponse{}, err
+	}
+	return oauthTokenResponse{
+		AccessToken: accessToken,
+		TokenType: "Bearer",
+		ExpiresIn: int(oauthAccessLifetime.Seconds()),
+		RefreshToken: refreshToken,
+		Scope: strings.Join(scopes, " "),
+	}, nil
+}
+
+// revokeOAuthGrant revokes every token of a grant after a code or refresh
+// token was replayed, which means it has leaked.
+func (cfg *apiConfig) revokeOAuthGrant(ctx context.Context, grantID uuid.UUID){
+	err := cfg.db.RevokeOAuthGrant(ctx, grantID)
+	if err != nil {
+		log.Printf("Error revoking oauth grant %v: %s", grantID, err)
+	}
+}
+
+// oauthToken is the token endpoint, supporting the authorization_code and
+// refresh_token grants.
+func (cfg *apiConfig) oauthToken(w http.ResponseWriter, r *http.Request){
+	client, ok := cfg.clientRequest(w, r)
+	if !ok {
+		return
+	}
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		cfg.exchangeAuthorizationCode(w, r, client)
+	case "refresh_token":
+		cfg.exchangeOAuthRefreshToken(w, r, client)
+	default:
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "Use authorization_code or refresh_token")
+	}
+}
+
+func (cfg *apiConfig) exchangeAuthorizationCode(w http.ResponseWriter, r *http.Request, client database.OauthClient){
+	code, err := cfg.db.GetOAuthCode(r.Context(), hashToken(r.PostForm.Get("code")))
+	if errors.Is(err, sql.ErrNoRows) || err == nil && code.ClientID != client.ID {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid authorization code")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting authorization code: %s", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Server Error, please try again")
+		return
+	}
+	if code.UsedAt.Valid {
+		log.Printf("Authorization code for grant %v was reused, revoking the grant", code.GrantID)
+		cfg.revokeOAuthGrant(r.Context(), code.GrantID)
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "Authorization code was already used")
+		return
+	}
+	if code.Expired {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "Authorization code has expired")
+		return
+	}
+	if r.PostForm.Get("redirect_uri") != code.RedirectUri {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "redirect_uri does not match the authorization request")
+		return
+	}
+	verifier := r.PostForm.Get("code_verifier")
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	if !validPKCEValue(verifier) || subtle.ConstantTimeCompare([]byte(challenge), []byte(code.CodeChallenge)) != 1 {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "code_verifier does not match the code_challenge")
+		return
+	}
+	consumed, err := cfg.db.ConsumeOAuthCode(r.Context(), code.CodeHash)
+	if err != nil {
+		log.Printf("Error using authorization code: %s", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Server Error, please try again")
+		return
+	}
+	if consumed != 1 {
+		cfg.revokeOAuthGrant(r.Context(), code.GrantID)
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "Authorization code was already used")
+		return
+	}
+	response, err := cfg.issueOAuthTokens(r.Context(), code.GrantID, client.ID, code.UserID, code.Scopes)
+	if err != nil {
+		log.Printf("Error issuing oauth tokens for %v: %s", code.UserID, err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Server Error, please try again")
+		return
+	}
+	writeOAuthJSON(w, response)
+}
+
+// exchangeOAuthRefreshToken rotates a refresh token like /api/refresh
+// does. A scope parameter may narrow the scopes of the new tokens.
+func (cfg *apiConfig) exchangeOAuthRefreshToken(w http.ResponseWriter, r *http.Request, client database.OauthClient){
+	token, err := cfg.db.GetOAuthToken(r.Context(), hashToken(r.PostForm.Get("refresh_token")))
+	if errors.Is(err, sql.ErrNoRows) || err == nil && (token.Kind != "refresh" || token.ClientID != client.ID) {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid refresh token")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting oauth refresh token: %s", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Server Error, please try again")
+		return
+	}
+	if token.RevokedAt.Valid {
+		log.Printf("Revoked oauth refresh token for grant %v was reused, revoking the grant", token.GrantID)
+		cfg.revokeOAuthGrant(r.Context(), token.GrantID)
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "Refresh token has been revoked")
+		return
+	}
+	if token.Expired {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "Refresh token has expired")
+		return
+	}
+	scopes := token.Scopes
+	if requested := strings.Fields(r.PostForm.Get("scope")); len(requested) > 0 {
+		for _, scope := range requested {
+			if !slices.Contains(token.Scopes, scope) {
+				writeOAuthError(w, http.StatusBadRequest, "invalid_scope", "Scope " + scope + " was not granted")
+				return
+			}
+		}
+		slices.Sort(requested)
+		scopes = slices.Compact(requested)
+	}
+	rotated, err := cfg.db.RotateOAuthRefreshToken(r.Context(), token.TokenHash)
+	if err != nil {
+		log.Printf("Error rotating oauth refresh token: %s", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Server Error, please try again")
+		return
+	}
+	if rotated != 1 {
+		cfg.revokeOAuthGrant(r.Context(), token.GrantID)
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "Refresh token has been revoked")
+		return
+	}
+	response, err := cfg.issueOAuthTokens(r.Context(), token.GrantID, client.ID, token.UserID, scopes)
+	if err != nil {
+		log.Printf("Error issuing oauth tokens for %v: %s", token.UserID, err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Server Error, please try again")
+		return
+	}
+	writeOAuthJSON(w, response)
+}
+
+// oauthRevoke implements RFC 7009. Revoking either token of a grant
+// revokes both, and unknown tokens are not an error.
+func (cfg *apiConfig) oauthRevoke(w http.ResponseWriter, r *http.Request){
+	client, ok := cfg.clientRequest(w, r)
+	if !ok {
+		return
+	}
+	token, err := cfg.db.GetOAuthToken(r.Context(), hashToken(r.PostForm.Get("token")))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error getting oauth token: %s", err)
+		writeOAuthError(w, http.StatusServiceUnavailable, "server_error", "Server Error, please try again")
+		return
+	}
+	if err == nil && token.ClientID == client.ID {
+		err = cfg.db.RevokeOAuthGrant(r.Context(), token.GrantID)
+		if err != nil {
+			log.Printf("Error revoking oauth grant %v: %s", token.GrantID, err)
+			writeOAuthError(w, http.StatusServiceUnavailable, "server_error", "Server Error, please try again")
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// oauthIntrospect implements RFC 7662. Clients can only introspect their
+// own tokens, anything else is reported as inactive.
+func (cfg *apiConfig) oauthIntrospect(w http.ResponseWriter, r *http.Request){
+	client, ok := cfg.clientRequest(w, r)
+	if !ok {
+		return
+	}
+	token, err := cfg.db.GetOAuthToken(r.Context(), hashToken(r.PostForm.Get("token")))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error getting oauth token: %s", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Server Error, please try again")
+		return
+	}
+	if err != nil || token.ClientID != client.ID || token.RevokedAt.Valid || token.Expired {
+		writeOAuthJSON(w, oauthIntrospection{Active: false})
+		return
+	}
+	tokenType := "Bearer"
+	if token.Kind == "refresh" {
+		tokenType = "refresh_token"
+	}
+	writeOAuthJSON(w, oauthIntrospection{
+		Active: true,
+		Scope: strings.Join(token.Scopes, " "),
+		ClientID: token.ClientID.String(),
+		Subject: token.UserID.String(),
+		TokenType: tokenType,
+		IssuedAt: token.IssuedUnix,
+		ExpiresAt: token.ExpiresUnix,
+	})
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/xsynch/chirpy/internal/auth"
+	"github.com/xsynch/chirpy/internal/database"
+	"github.com/xsynch/chirpy/internal/oidc"
+)
+
+const oidcLoginLifetime = 10 * time.Minute
+
+// oidcStateCookie ties the callback to the browser that started the login,
+// so nobody can log a victim into the attacker's account.
+const oidcStateCookie = "chirpy_oidc_state"
+
+var errUnverifiedAccount = errors.New("an unverified account uses this email")
+var errUnverifiedIdentity = errors.New("provider has not verified the email")
+
+func (cfg *apiConfig) getOIDCProviders(w http.ResponseWriter, r *http.Request){
+	names := make([]string, 0, len(cfg.oidcProviders))
+	for name := range cfg.oidcProviders {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	dst, err := json.Marshal(names)
+	if err != nil {
+		log.Printf("Error marshalling providers: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dst)
+}
+
+// startOIDCLogin sends the user to the provider to sign in.
+func (cfg *apiConfig) startOIDCLogin(w http.ResponseWriter, r *http.Request){
+	name := r.PathValue("provider")
+	provider, ok := cfg.oidcProviders[name]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Unknown login provider"))
+		return
+	}
+	state, stateHash, err := newOneTimeToken()
+	if err != nil {
+		log.Printf("Error creating login state: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	nonce, _, err := newOneTimeToken()
+	if err != nil {
+		log.Printf("Error creating login nonce: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	verifier, err := oidc.NewVerifier()
+	if err != nil {
+		log.Printf("Error creating code verifier: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	redirect, err := provider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Printf("Error contacting login provider %s: %s", name, err)
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("The login provider is unavailable, please try again"))
+		return
+	}
+	err = cfg.db.InsertOIDCLoginState(r.Context(), database.InsertOIDCLoginStateParams{
+		StateHash: stateHash,
+		Provider: name,
+		Nonce: nonce,
+		CodeVerifier: verifier,
+		ExpiresAt: time.Now().UTC().Add(oidcLoginLifetime),
+	})
+	if err != nil {
+		log.Printf("Error saving login state: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name: oidcStateCookie,
+		Value: state,
+		Path: "/api/login/oidc/",
+		MaxAge: int(oidcLoginLifetime.Seconds()),
+		HttpOnly: true,
+		Secure: strings.HasPrefix(cfg.public_url, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// finishOIDCLogin is where the provider sends the user back. It verifies
+// the ID token, finds or creates the Chirpy user and logs them in the same
+// way chirpLogin does.
+func (cfg *apiConfig) finishOIDCLogin(w http.ResponseWriter, r *http.Request){
+	name := r.PathValue("provider")
+	provider, ok := cfg.oidcProviders[name]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Unknown login provider"))
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/api/login/oidc/", MaxAge: -1})
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Login was cancelled or refused by the provider: " + query.Get("error")))
+		return
+	}
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Login state does not match, please start again"))
+		return
+	}
+	login, err := cfg.db.ConsumeOIDCLoginState(r.Context(), database.ConsumeOIDCLoginStateParams{StateHash: hashToken(state), Provider: name})
+	if err == nil && !login.ExpiresAt.After(time.Now()) {
+		err = sql.ErrNoRows
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Login has expired, please start again"))
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting login state: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	claims, err := provider.Authenticate(r.Context(), query.Get("code"), login.CodeVerifier, login.Nonce)
+	if err != nil {
+		log.Printf("Error authenticating with login provider %s: %s", name, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Could not verify the login with the provider"))
+		return
+	}
+	user, err := cfg.oidcUser(r.Context(), name, claims)
+	if errors.Is(err, errUnverifiedIdentity) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("The login provider has not verified your email address"))
+		return
+	}
+	if errors.Is(err, errUnverifiedAccount) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("An unverified Chirpy account already uses this email, verify it or log in with its password first"))
+		return
+	}
+	if err != nil {
+		log.Printf("Error finding user for %s login %s: %s", name, claims.Subject, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	if user.DeletedAt.Valid {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Account is scheduled for deletion, restore it with POST /api/users/restore"))
+		return
+	}
+	if user.TotpEnabledAt.Valid {
+		cfg.startLoginChallenge(w, r, user)
+		return
+	}
+	cfg.issueSession(w, r, user)
+}
+
+// oidcUser finds the user linked to a provider account. The first login
+// links an existing user with the same verified email, or creates one.
+// Unverified emails are never linked, or whoever signed up with the
+// address first could take over the account.
+func (cfg *apiConfig) oidcUser(ctx context.Context, provider string, claims oidc.Claims) (database.User, error){
+	identity, err := cfg.db.GetUserIdentity(ctx, database.GetUserIdentityParams{Provider: provider, Subject: claims.Subject})
+	if err == nil {
+		err = cfg.db.TouchUserIdentity(ctx, database.TouchUserIdentityParams{Provider: provider, Subject: claims.Subject})
+		if err != nil {
+			log.Printf("Error recording login of %v: %s", identity.UserID, err)
+		}
+		return cfg.db.GetUserByID(ctx, identity.UserID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+	if !claims.EmailVerified || !validEmail(claims.Email) {
+		return database.User{}, errUnverifiedIdentity
+	}
+	user, err := cfg.db.LookupUser(ctx, claims.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		user, err = cfg.createOIDCUser(ctx, claims.Email)
+	} else if err == nil && !user.EmailVerifiedAt.Valid {
+		err = errUnverifiedAccount
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+	err = cfg.db.InsertUserIdentity(ctx, database.InsertUserIdentityParams{Provider: provider, Subject: claims.Subject, UserID: user.ID, Email: claims.Email})
+	if err != nil {
+		return database.User{}, err
+	}
+	return user, nil
+}
+
+// createOIDCUser creates a user whose email the provider has verified. It
+// gets a random password, which the user can replace with
+// POST /api/password/forgot if they ever want to log in without the
+// provider.
+func (cfg *apiConfig) createOIDCUser(ctx context.Context, email string) (database.User, error){
+	password, _, err := newOneTimeToken()
+	if err != nil {
+		return database.User{}, err
+	}
+	hashed, err := auth.HashPassword(password)
+	if err != nil {
+		return database.User{}, err
+	}
+	user, err := cfg.db.CreateUser(ctx, database.CreateUserParams{Email: email, HashedPassword: hashed})
+	if err != nil {
+		return database.User{}, err
+	}
+	return cfg.db.MarkEmailVerified(ctx, database.MarkEmailVerifiedParams{ID: user.ID, Email: email})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/xsynch/chirpy/internal/database"
+	"github.com/xsynch/chirpy/internal/oidc"
+)
+
+func TestOIDCUserUnverifiedEmail(t *testing.T) {
+	cfg, _ := testConfig(t)
+	ctx := context.Background()
+	email := uuid.NewString() + "@example.com"
+	tests := []struct {
+		name   string
+		claims oidc.Claims
+	}{
+		{name: "unverified", claims: oidc.Claims{Subject: uuid.NewString(), Email: email}},
+		{name: "verified but not an email", claims: oidc.Claims{Subject: uuid.NewString(), Email: "alice", EmailVerified: true}},
+		{name: "verified but empty", claims: oidc.Claims{Subject: uuid.NewString(), EmailVerified: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := cfg.oidcUser(ctx, "test", tt.claims)
+			if !errors.Is(err, errUnverifiedIdentity) {
+				t.Fatalf("oidcUser error = %v, want errUnverifiedIdentity", err)
+			}
+			_, err = cfg.db.GetUserIdentity(ctx, database.GetUserIdentityParams{Provider: "test", Subject: tt.claims.Subject})
+			if !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("identity was linked, lookup error = %v", err)
+			}
+		})
+	}
+	if _, err := cfg.db.LookupUser(ctx, email); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("a user was created for an unverified email, lookup error = %v", err)
+	}
+}
+
+func TestOIDCUserUnverifiedAccount(t *testing.T) {
+	cfg, _ := testConfig(t)
+	ctx := context.Background()
+	// someone signed up with the address but never verified it
+	squatter, err := cfg.db.CreateUser(ctx, database.CreateUserParams{Email: uuid.NewString() + "@example.com", HashedPassword: "unused"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := oidc.Claims{Subject: uuid.NewString(), Email: squatter.Email, EmailVerified: true}
+	_, err = cfg.oidcUser(ctx, "test", claims)
+	if !errors.Is(err, errUnverifiedAccount) {
+		t.Fatalf("oidcUser error = %v, want errUnverifiedAccount", err)
+	}
+	_, err = cfg.db.GetUserIdentity(ctx, database.GetUserIdentityParams{Provider: "test", Subject: claims.Subject})
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("identity was linked to the unverified account, lookup error = %v", err)
+	}
+}
+
+func TestOIDCUserLinksVerifiedEmail(t *testing.T) {
+	cfg, _ := testConfig(t)
+	ctx := context.Background()
+	claims := oidc.Claims{Subject: uuid.NewString(), Email: uuid.NewString() + "@example.com", EmailVerified: true}
+	user, err := cfg.oidcUser(ctx, "test", claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != claims.Email || !user.EmailVerifiedAt.Valid {
+		t.Errorf("created user %s verified %v, want %s verified", user.Email, user.EmailVerifiedAt.Valid, claims.Email)
+	}
+	// once linked, the identity logs in whatever the provider says about
+	// the email now
+	claims.EmailVerified = false
+	again, err := cfg.oidcUser(ctx, "test", claims)
+	if err != nil || again.ID != user.ID {
+		t.Errorf("second login = %v, %v, want %v", again.ID, err, user.ID)
+	}
+}
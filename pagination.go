@@ -0,0 +1,128 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const defaultPageSize = 20
+const maxPageSize = 100
+
+// chirpCursor marks the last row of a page. Rows are ordered on
+// (created_at, id) so chirps created in the same instant still page stably.
+type chirpCursor struct {
+	CreatedAt time.Time
+	ID uuid.UUID
+}
+
+type pageRequest struct {
+	Limit int
+	Cursor *chirpCursor
+}
+
+func encodeCursor(c chirpCursor) string {
+	raw := fmt.Sprintf("%s|%s", c.CreatedAt.UTC().Format(time.RFC3339Nano), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (chirpCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return chirpCursor{}, errors.New("malformed cursor")
+	}
+	createdAt, id, found := strings.Cut(string(raw), "|")
+	if !found {
+		return chirpCursor{}, errors.New("malformed cursor")
+	}
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return chirpCursor{}, errors.New("malformed cursor")
+	}
+	parsedID, err := uuid.Parse(id)
+	if err != nil {
+		return chirpCursor{}, errors.New("malformed cursor")
+	}
+	return chirpCursor{CreatedAt: t, ID: parsedID}, nil
+}
+
+// rankCursor marks the last row of a page of search results ordered by
+// relevance, highest rank first and then by id.
+type rankCursor struct {
+	Rank float32
+	ID uuid.UUID
+}
+
+func encodeRankCursor(c rankCursor) string {
+	raw := fmt.Sprintf("%s|%s", strconv.FormatFloat(float64(c.Rank), 'g', -1, 32), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeRankCursor(s string) (rankCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return rankCursor{}, errors.New("malformed cursor")
+	}
+	rank, id, found := strings.Cut(string(raw), "|")
+	if !found {
+		return rankCursor{}, errors.New("malformed cursor")
+	}
+	parsedRank, err := strconv.ParseFloat(rank, 32)
+	if err != nil {
+		return rankCursor{}, errors.New("malformed cursor")
+	}
+	parsedID, err := uuid.Parse(id)
+	if err != nil {
+		return rankCursor{}, errors.New("malformed cursor")
+	}
+	return rankCursor{Rank: float32(parsedRank), ID: parsedID}, nil
+}
+
+// cursorArgs are the cursor parameters of the paginated queries, both NULL
+// on the first page.
+func (p pageRequest) cursorArgs() (sql.NullTime, uuid.NullUUID){
+	if p.Cursor == nil {
+		return sql.NullTime{}, uuid.NullUUID{}
+	}
+	return sql.NullTime{Time: p.Cursor.CreatedAt, Valid: true}, uuid.NullUUID{UUID: p.Cursor.ID, Valid: true}
+}
+
+// parseLimit reads the limit query parameter, capped at maxPageSize.
+func parseLimit(query url.Values) (int, error) {
+	val := query.Get("limit")
+	if val == "" {
+		return defaultPageSize, nil
+	}
+	limit, err := strconv.Atoi(val)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("invalid limit: %s", val)
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	return limit, nil
+}
+
+// parsePageRequest reads the limit and cursor query parameters.
+func parsePageRequest(query url.Values) (pageRequest, error) {
+	limit, err := parseLimit(query)
+	if err != nil {
+		return pageRequest{}, err
+	}
+	page := pageRequest{Limit: limit}
+	if val := query.Get("cursor"); val != "" {
+		c, err := decodeCursor(val)
+		if err != nil {
+			return pageRequest{}, err
+		}
+		page.Cursor = &c
+	}
+	return page, nil
+}
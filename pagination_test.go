@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	want := chirpCursor{
+		CreatedAt: time.Date(2024, 11, 3, 14, 5, 6, 123456789, time.UTC),
+		ID:        uuid.MustParse("0b4d8d4e-5f3a-4c39-9d61-1f1bd5f6a1e2"),
+	}
+	got, err := decodeCursor(encodeCursor(want))
+	if err != nil {
+		t.Fatalf("decodeCursor: %s", err)
+	}
+	if !got.CreatedAt.Equal(want.CreatedAt) || got.ID != want.ID {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestCursorNormalisesToUTC(t *testing.T) {
+	local := time.Date(2024, 11, 3, 9, 0, 0, 0, time.FixedZone("EST", -5*60*60))
+	got, err := decodeCursor(encodeCursor(chirpCursor{CreatedAt: local, ID: uuid.New()}))
+	if err != nil {
+		t.Fatalf("decodeCursor: %s", err)
+	}
+	if !got.CreatedAt.Equal(local) || got.CreatedAt.Location() != time.UTC {
+		t.Errorf("got %v, want %v in UTC", got.CreatedAt, local)
+	}
+}
+
+func TestDecodeCursorRejectsGarbage(t *testing.T) {
+	tests := map[string]string{
+		"not base64":   "%%%",
+		"no separator": "bm9zZXBhcmF0b3I",
+		"bad time":     encodeRaw("yesterday|0b4d8d4e-5f3a-4c39-9d61-1f1bd5f6a1e2"),
+		"bad id":       encodeRaw("2024-11-03T14:05:06Z|not-a-uuid"),
+	}
+	for name, cursor := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := decodeCursor(cursor); err == nil {
+				t.Errorf("decodeCursor(%q) succeeded", cursor)
+			}
+		})
+	}
+}
+
+func TestRankCursorRoundTrip(t *testing.T) {
+	want := rankCursor{Rank: 0.0607927, ID: uuid.New()}
+	got, err := decodeRankCursor(encodeRankCursor(want))
+	if err != nil {
+		t.Fatalf("decodeRankCursor: %s", err)
+	}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestParsePageRequest(t *testing.T) {
+	cursor := encodeCursor(chirpCursor{CreatedAt: time.Unix(1700000000, 0).UTC(), ID: uuid.New()})
+	tests := []struct {
+		name    string
+		query   string
+		limit   int
+		cursor  bool
+		wantErr bool
+	}{
+		{"defaults", "", defaultPageSize, false, false},
+		{"limit", "limit=5", 5, false, false},
+		{"limit capped", "limit=100000", maxPageSize, false, false},
+		{"zero limit", "limit=0", 0, false, true},
+		{"negative limit", "limit=-3", 0, false, true},
+		{"non numeric limit", "limit=ten", 0, false, true},
+		{"cursor", "cursor=" + cursor, defaultPageSize, true, false},
+		{"bad cursor", "cursor=zzz", 0, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			page, err := parsePageRequest(query)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parsePageRequest(%q) succeeded", tt.query)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsePageRequest(%q): %s", tt.query, err)
+			}
+			if page.Limit != tt.limit || (page.Cursor != nil) != tt.cursor {
+				t.Errorf("got limit %d cursor %v, want %d %v", page.Limit, page.Cursor != nil, tt.limit, tt.cursor)
+			}
+			createdAt, id := page.cursorArgs()
+			if createdAt.Valid != tt.cursor || id.Valid != tt.cursor {
+				t.Errorf("cursorArgs valid = %v %v, want %v", createdAt.Valid, id.Valid, tt.cursor)
+			}
+		})
+	}
+}
+
+func encodeRaw(s string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
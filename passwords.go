@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/xsynch/chirpy/internal/auth"
+	"github.com/xsynch/chirpy/internal/database"
+	"github.com/xsynch/chirpy/internal/mailer"
+)
+
+const passwordResetTTL = time.Hour
+
+// forgotPassword always answers 202 with an empty body, whether or not the
+// email belongs to an account. The lookup and send happen after the
+// response so the timing does not give it away either.
+func (cfg *apiConfig) forgotPassword(w http.ResponseWriter, r *http.Request){
+	request := struct {
+		Email string `json:"email"`
+	}{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&request)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Data Error"))
+		return
+	}
+	go func(email string) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		err := cfg.sendPasswordReset(ctx, email)
+		if err != nil {
+			log.Printf("Error sending password reset: %s", err)
+		}
+	}(request.Email)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) sendPasswordReset(ctx context.Context, email string) error {
+	user, err := cfg.db.LookupUser(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	token, tokenHash, err := newOneTimeToken()
+	if err != nil {
+		return err
+	}
+	err = cfg.db.DeletePasswordResetTokens(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	err = cfg.db.InsertPasswordResetToken(ctx, database.InsertPasswordResetTokenParams{
+		TokenHash: tokenHash,
+		UserID: user.ID,
+		TtlSeconds: int64(passwordResetTTL.Seconds()),
+	})
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf("Someone asked to reset the password for your Chirpy account.\n\nTo choose a new password, send this token with your new password to %s/api/password/reset:\n\n%s\n\nThe token can be used once and expires in one hour. If you did not ask for this, you can ignore this email.\n", cfg.public_url, token)
+	return cfg.mailer.Send(ctx, mailer.Message{To: user.Email, Subject: "Reset your Chirpy password", Body: body})
+}
+
+// resetPassword sets a new password from an emailed token and logs the
+// account out everywhere by revoking its refresh tokens.
+func (cfg *apiConfig) resetPassword(w http.ResponseWriter, r *http.Request){
+	request := struct {
+		Token string `json:"token"`
+		Password string `json:"password"`
+	}{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&request)
+	if err != nil || request.Token == "" || request.Password == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("A reset token and new password are required"))
+		return
+	}
+	password, err := auth.HashPassword(request.Password)
+	if err != nil {
+		log.Printf("Error hashing password: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Server error, please try again."))
+		return
+	}
+	token, err := cfg.db.ConsumePasswordResetToken(r.Context(), hashToken(request.Token))
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid or expired reset token"))
+		return
+	}
+	if err != nil {
+		log.Printf("Error consuming reset token: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	err = cfg.db.SetUserPassword(r.Context(), database.SetUserPasswordParams{ID: token.UserID, HashedPassword: password})
+	if err != nil {
+		log.Printf("Error resetting password for %v: %s", token.UserID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	err = cfg.db.RevokeUserRefreshTokens(r.Context(), token.UserID)
+	if err != nil {
+		log.Printf("Error revoking refresh tokens for %v: %s", token.UserID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
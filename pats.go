@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/xsynch/chirpy/internal/database"
+)
+
+// patPrefix marks personal access tokens so they are not mistaken for JWTs
+// and are easy to spot when leaked.
+const patPrefix = "chirpy_pat_"
+
+const maxTokenNameLength = 100
+const maxTokenExpiryDays = 3650
+
+func patResponse(pat database.PersonalAccessToken) personalAccessToken {
+	response := personalAccessToken{ID: pat.ID, Name: pat.Name, Scopes: pat.Scopes, CreatedAt: pat.CreatedAt}
+	if pat.LastUsedAt.Valid {
+		response.LastUsedAt = &pat.LastUsedAt.Time
+	}
+	if pat.ExpiresAt.Valid {
+		response.ExpiresAt = &pat.ExpiresAt.Time
+	}
+	return response
+}
+
+// createToken issues a personal access token. The token itself is only
+// ever shown in this response.
+func (cfg *apiConfig) createToken(w http.ResponseWriter, r *http.Request){
+	userID := authUserID(r)
+	request := struct {
+		Name string `json:"name"`
+		Scopes []string `json:"scopes"`
+		ExpiresInDays int `json:"expires_in_days"`
+	}{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&request)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Data Error"))
+		return
+	}
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" || len(request.Name) > maxTokenNameLength {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Tokens need a name of up to 100 characters"))
+		return
+	}
+	if len(request.Scopes) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Tokens need at least one scope"))
+		return
+	}
+	for _, scope := range request.Scopes {
+		if !slices.Contains(grantableScopes, scope) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Unknown scope " + scope + ", use one of " + strings.Join(grantableScopes, ", ")))
+			return
+		}
+	}
+	if request.ExpiresInDays < 0 || request.ExpiresInDays > maxTokenExpiryDays {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("expires_in_days must be between 0 and %d", maxTokenExpiryDays)))
+		return
+	}
+	slices.Sort(request.Scopes)
+	request.Scopes = slices.Compact(request.Scopes)
+
+	buf := make([]byte, 32)
+	_, err = rand.Read(buf)
+	if err != nil {
+		log.Printf("Error creating token: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	token := patPrefix + base64.RawURLEncoding.EncodeToString(buf)
+	params := database.InsertPersonalAccessTokenParams{
+		ID: uuid.New(),
+		UserID: userID,
+		Name: request.Name,
+		TokenHash: hashToken(token),
+		Scopes: request.Scopes,
+		ExpiresInDays: int32(request.ExpiresInDays),
+	}
+	pat, err := cfg.db.InsertPersonalAccessToken(r.Context(), params)
+	if err != nil {
+		log.Printf("Error saving token for %v: %s", userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	response := patResponse(pat)
+	response.Token = token
+	dst, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling token: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(dst)
+}
+
+func (cfg *apiConfig) getTokens(w http.ResponseWriter, r *http.Request){
+	userID := authUserID(r)
+	results, err := cfg.db.GetUserPersonalAccessTokens(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error getting tokens for %v: %s", userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	tokens := make([]personalAccessToken, 0, len(results))
+	for _, val := range results {
+		tokens = append(tokens, patResponse(val))
+	}
+	dst, err := json.Marshal(tokens)
+	if err != nil {
+		log.Printf("Error marshalling tokens: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dst)
+}
+
+func (cfg *apiConfig) revokeToken(w http.ResponseWriter, r *http.Request){
+	userID := authUserID(r)
+	tokenID, err := uuid.Parse(r.PathValue("tokenID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid token id"))
+		return
+	}
+	revoked, err := cfg.db.RevokePersonalAccessToken(r.Context(), database.RevokePersonalAccessTokenParams{ID: tokenID, UserID: userID})
+	if err != nil {
+		log.Printf("Error revoking token %v: %s", tokenID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	if revoked == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Token not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/xsynch/chirpy/internal/database"
+)
+
+var handlePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,30}$`)
+
+// reservedHandles would collide with fixed routes under /api/users/.
+var reservedHandles = map[string]bool{"me": true}
+
+const maxDisplayNameLength = 50
+const maxBioLength = 160
+const maxWebsiteLength = 200
+
+// authorsByID looks up the compact author object for each user id.
+func (cfg *apiConfig) authorsByID(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]chirpAuthor, error){
+	authors := map[uuid.UUID]chirpAuthor{}
+	if len(ids) == 0 {
+		return authors, nil
+	}
+	results, err := cfg.db.GetAuthorsByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, val := range results {
+		author := chirpAuthor{ID: val.ID, DisplayName: val.DisplayName}
+		if val.Handle.Valid {
+			author.Handle = val.Handle.String
+		}
+		if val.AvatarKey.Valid {
+			author.AvatarURL = cfg.media.URL(val.AvatarKey.String)
+		}
+		authors[val.ID] = author
+	}
+	return authors, nil
+}
+
+func (cfg *apiConfig) buildProfile(ctx context.Context, user database.User) (publicProfile, error){
+	authors, err := cfg.authorsByID(ctx, []uuid.UUID{user.ID})
+	if err != nil {
+		return publicProfile{}, err
+	}
+	profile := publicProfile{
+		ID: user.ID,
+		DisplayName: user.DisplayName,
+		Bio: user.Bio,
+		AvatarURL: authors[user.ID].AvatarURL,
+		Website: user.Website,
+		CreatedAt: user.CreatedAt,
+		IsChirpyRd: user.IsChirpyRed,
+	}
+	if user.Handle.Valid {
+		profile.Handle = user.Handle.String
+	}
+	return profile, nil
+}
+
+// getProfile looks a user up by id or handle. The email address is never
+// part of the public profile.
+func (cfg *apiConfig) getProfile(w http.ResponseWriter, r *http.Request){
+	handleOrID := strings.TrimPrefix(r.PathValue("handleOrID"), "@")
+	var user database.User
+	var err error
+	if userID, parseErr := uuid.Parse(handleOrID); parseErr == nil {
+		user, err = cfg.db.GetUserByID(r.Context(), userID)
+	} else {
+		user, err = cfg.db.GetUserByHandle(r.Context(), handleOrID)
+	}
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && user.DeletedAt.Valid) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("User not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Error looking up user %s: %s", handleOrID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	profile, err := cfg.buildProfile(r.Context(), user)
+	if err != nil {
+		log.Printf("Error building profile for %v: %s", user.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	dst, err := json.Marshal(profile)
+	if err != nil {
+		log.Printf("Error marshalling %v: %s", profile, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dst)
+}
+
+// updateProfile applies a partial update: fields left out of the request
+// keep their current value.
+func (cfg *apiConfig) updateProfile(w http.ResponseWriter, r *http.Request){
+	userID := authUserID(r)
+	update := profileUpdate{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&update)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Data Error"))
+		return
+	}
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error looking up user %v: %s", userID, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Invalid User"))
+		return
+	}
+
+	params := database.UpdateUserProfileParams{
+		ID: user.ID,
+		Handle: user.Handle,
+		DisplayName: user.DisplayName,
+		Bio: user.Bio,
+		AvatarMediaID: user.AvatarMediaID,
+		Website: user.Website,
+	}
+	if update.Handle != nil {
+		handle := strings.TrimPrefix(*update.Handle, "@")
+		if !handlePattern.MatchString(handle) || reservedHandles[strings.ToLower(handle)] {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Handles must be 3 to 30 letters, digits or underscores"))
+			return
+		}
+		params.Handle = sql.NullString{String: handle, Valid: true}
+	}
+	if update.DisplayName != nil {
+		if utf8.RuneCountInString(*update.DisplayName) > maxDisplayNameLength {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Display name is too long"))
+			return
+		}
+		params.DisplayName = strings.TrimSpace(*update.DisplayName)
+	}
+	if update.Bio != nil {
+		if utf8.RuneCountInString(*update.Bio) > maxBioLength {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Bio is too long"))
+			return
+		}
+		params.Bio = strings.TrimSpace(*update.Bio)
+	}
+	if update.Website != nil {
+		website := strings.TrimSpace(*update.Website)
+		if website != "" {
+			parsed, err := url.Parse(website)
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || len(website) > maxWebsiteLength {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Website must be an http or https URL"))
+				return
+			}
+		}
+		params.Website = website
+	}
+	if update.AvatarMediaID != nil {
+		if *update.AvatarMediaID == uuid.Nil {
+			params.AvatarMediaID = uuid.NullUUID{}
+		} else {
+			media, err := cfg.db.GetMediaByIDs(r.Context(), []uuid.UUID{*update.AvatarMediaID})
+			if err != nil {
+				log.Printf("Error looking up media %v: %s", *update.AvatarMediaID, err)
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("Server Error, please try again"))
+				return
+			}
+			if len(media) != 1 || media[0].UserID != userID {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Avatar must be an image you uploaded"))
+				return
+			}
+			params.AvatarMediaID = uuid.NullUUID{UUID: media[0].ID, Valid: true}
+		}
+	}
+
+	updated, err := cfg.db.UpdateUserProfile(r.Context(), params)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte("Handle is already taken"))
+			return
+		}
+		log.Printf("Error updating profile of %v: %s", userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error updating the user"))
+		return
+	}
+	profile, err := cfg.buildProfile(r.Context(), updated)
+	if err != nil {
+		log.Printf("Error building profile for %v: %s", updated.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	dst, err := json.Marshal(profile)
+	if err != nil {
+		log.Printf("Error marshalling %v: %s", profile, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dst)
+}
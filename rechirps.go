@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/xsynch/chirpy/internal/database"
+)
+
+// feedItem is an entry in an author listing or timeline: either a chirp or a
+// rechirp of one. Rechirps sort by when they were shared, not by when the
+// original chirp was written.
+type feedItem struct {
+	chirp database.Chirp
+	rechirp *database.Rechirp
+}
+
+func (f feedItem) cursor() chirpCursor {
+	if f.rechirp != nil {
+		return chirpCursor{CreatedAt: f.rechirp.CreatedAt, ID: f.rechirp.ID}
+	}
+	return chirpCursor{CreatedAt: f.chirp.CreatedAt, ID: f.chirp.ID}
+}
+
+// before orders cursors the same way Postgres orders (created_at, id).
+func (c chirpCursor) before(other chirpCursor) bool {
+	if !c.CreatedAt.Equal(other.CreatedAt) {
+		return c.CreatedAt.Before(other.CreatedAt)
+	}
+	return bytes.Compare(c.ID[:], other.ID[:]) < 0
+}
+
+func chirpFeedItems(chirps []database.Chirp) []feedItem {
+	items := make([]feedItem, 0, len(chirps))
+	for _, val := range chirps {
+		items = append(items, feedItem{chirp: val})
+	}
+	return items
+}
+
+func rechirpFeedItem(rechirp database.Rechirp, chirp database.Chirp) feedItem {
+	return feedItem{chirp: chirp, rechirp: &rechirp}
+}
+
+func sortFeed(items []feedItem, desc bool) {
+	sort.SliceStable(items, func(i, j int) bool {
+		if desc {
+			return items[j].cursor().before(items[i].cursor())
+		}
+		return items[i].cursor().before(items[j].cursor())
+	})
+}
+
+func (cfg *apiConfig) buildFeedResponses(ctx context.Context, items []feedItem, viewerID uuid.UUID) ([]chirpSuccess, error){
+	chirps := make([]database.Chirp, 0, len(items))
+	for _, val := range items {
+		chirps = append(chirps, val.chirp)
+	}
+	responses, err := cfg.buildChirpResponses(ctx, chirps, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	for idx, val := range items {
+		if val.rechirp == nil {
+			continue
+		}
+		rechirpedBy := val.rechirp.UserID
+		rechirpedAt := val.rechirp.CreatedAt
+		responses[idx].RechirpedBy = &rechirpedBy
+		responses[idx].RechirpedAt = &rechirpedAt
+	}
+	return responses, nil
+}
+
+func (cfg *apiConfig) rechirp(w http.ResponseWriter, r *http.Request){
+	userID := authUserID(r)
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid chirp id"))
+		return
+	}
+	_, err = cfg.db.GetOneChirp(r.Context(), chirpID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Error finding chirp"))
+		log.Printf("There was an error getting chirp %v: %s", chirpID, err)
+		return
+	}
+	params := database.InsertRechirpParams{UserID: userID, ChirpID: chirpID}
+	err = cfg.db.InsertRechirp(r.Context(), params)
+	if err != nil {
+		log.Printf("Error rechirping %v for user %v: %s", chirpID, userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) undoRechirp(w http.ResponseWriter, r *http.Request){
+	userID := authUserID(r)
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid chirp id"))
+		return
+	}
+	params := database.DeleteRechirpParams{UserID: userID, ChirpID: chirpID}
+	err = cfg.db.DeleteRechirp(r.Context(), params)
+	if err != nil {
+		log.Printf("Error removing rechirp of %v for user %v: %s", chirpID, userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
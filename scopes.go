@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/xsynch/chirpy/internal/auth"
+)
+
+const (
+	scopeChirpsRead = "chirps:read"
+	scopeChirpsWrite = "chirps:write"
+	scopeMediaWrite = "media:write"
+	scopeFollowsWrite = "follows:write"
+	scopeProfileWrite = "profile:write"
+	scopeAccountRead = "account:read"
+	scopeAccountWrite = "account:write"
+	// scopeSession is never granted to a token, only a login session has
+	// it. It guards managing 2FA, sessions and tokens themselves.
+	scopeSession = "session"
+)
+
+// grantableScopes are the scopes a personal access token or OAuth client
+// can be given.
+var grantableScopes = []string{scopeChirpsRead, scopeChirpsWrite, scopeMediaWrite, scopeFollowsWrite, scopeProfileWrite, scopeAccountRead, scopeAccountWrite}
+
+// principal is who a request is authenticated as. A login session's access
+// token carries every scope; other tokens carry the scopes they were
+// granted.
+type principal struct {
+	UserID uuid.UUID
+	Scopes []string
+	AllScopes bool
+}
+
+func (p principal) has(scope string) bool {
+	return p.AllScopes || slices.Contains(p.Scopes, scope)
+}
+
+type principalKey struct{}
+
+var errInvalidToken = errors.New("invalid token")
+
+// authenticate resolves the bearer token of r, a personal access token, an
+// OAuth access token or a JWT from a login.
+func (cfg *apiConfig) authenticate(r *http.Request) (principal, error){
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return principal{}, err
+	}
+	if strings.HasPrefix(token, patPrefix) {
+		pat, err := cfg.db.GetPersonalAccessToken(r.Context(), hashToken(token))
+		if errors.Is(err, sql.ErrNoRows) {
+			return principal{}, errInvalidToken
+		}
+		if err != nil {
+			return principal{}, err
+		}
+		err = cfg.db.TouchPersonalAccessToken(r.Context(), pat.ID)
+		if err != nil {
+			log.Printf("Error recording use of token %v: %s", pat.ID, err)
+		}
+		return principal{UserID: pat.UserID, Scopes: pat.Scopes}, nil
+	}
+	if strings.HasPrefix(token, oauthAccessPrefix) {
+		grant, err := cfg.db.GetOAuthToken(r.Context(), hashToken(token))
+		if errors.Is(err, sql.ErrNoRows) {
+			return principal{}, errInvalidToken
+		}
+		if err != nil {
+			return principal{}, err
+		}
+		if grant.Kind != "access" || grant.RevokedAt.Valid || grant.Expired {
+			return principal{}, errInvalidToken
+		}
+		return principal{UserID: grant.UserID, Scopes: grant.Scopes}, nil
+	}
+	userID, err := cfg.validateJWT(token)
+	if err != nil {
+		return principal{}, err
+	}
+	// deleting an account revokes its refresh tokens, but access tokens
+	// stay valid until they expire unless they are refused here
+	active, err := cfg.db.IsUserActive(r.Context(), userID)
+	if err != nil {
+		return principal{}, err
+	}
+	if !active {
+		return principal{}, errInvalidToken
+	}
+	return principal{UserID: userID, AllScopes: true}, nil
+}
+
+// middlewareAuth only lets requests through whose token has scope. The
+// handler finds the caller with authUserID.
+func (cfg *apiConfig) middlewareAuth(scope string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
+		if r.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("User must be logged in"))
+			return
+		}
+		caller, err := cfg.authenticate(r)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Error validating user"))
+			return
+		}
+		if !caller.has(scope) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Token is missing the " + scope + " scope"))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, caller)))
+	})
+}
+
+// authUserID is the caller of a handler behind middlewareAuth.
+func authUserID(r *http.Request) uuid.UUID {
+	caller, _ := r.Context().Value(principalKey{}).(principal)
+	return caller.UserID
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xsynch/chirpy/internal/database"
+)
+
+// searchChirps runs a full-text search over chirp bodies. q accepts the
+// websearch syntax: "quoted phrases", or, and -excluded words. Results page
+// like getChirps; sort=relevance orders by rank instead of creation time.
+func (cfg *apiConfig) searchChirps(w http.ResponseWriter, r *http.Request){
+	query := r.URL.Query()
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Missing search query"))
+		return
+	}
+	limit, err := parseLimit(query)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	var authorID uuid.NullUUID
+	if authorid := query.Get("author_id"); authorid != "" {
+		authParsed, err := uuid.Parse(authorid)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid author id"))
+			return
+		}
+		authorID = uuid.NullUUID{UUID: authParsed, Valid: true}
+	}
+	var since, until sql.NullTime
+	for param, dst := range map[string]*sql.NullTime{"since": &since, "until": &until} {
+		val := query.Get(param)
+		if val == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid " + param + ", expected an RFC 3339 timestamp"))
+			return
+		}
+		*dst = sql.NullTime{Time: t.UTC(), Valid: true}
+	}
+
+	sortOrder := query.Get("sort")
+	results := chirpPage{}
+	var chirps []database.Chirp
+	if sortOrder == "relevance" {
+		params := database.SearchChirpsByRelevanceParams{
+			Query: q,
+			AuthorID: authorID,
+			Since: since,
+			Until: until,
+			PageSize: int32(limit + 1),
+		}
+		if val := query.Get("cursor"); val != "" {
+			c, err := decodeRankCursor(val)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			params.CursorRank = sql.NullFloat64{Float64: float64(c.Rank), Valid: true}
+			params.CursorID = uuid.NullUUID{UUID: c.ID, Valid: true}
+		}
+		rows, err := cfg.db.SearchChirpsByRelevance(r.Context(), params)
+		if err != nil {
+			log.Printf("Error searching chirps for %q: %s", q, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Error searching chirps"))
+			return
+		}
+		if len(rows) > limit {
+			rows = rows[:limit]
+			last := rows[len(rows)-1]
+			results.NextCursor = encodeRankCursor(rankCursor{Rank: last.Rank, ID: last.ID})
+		}
+		for _, val := range rows {
+			chirps = append(chirps, chirpFromColumns(chirpColumns{val.ID, val.CreatedAt, val.UpdatedAt, val.Body, val.UserID, val.InReplyTo, val.QuotedChirpID}))
+		}
+	} else {
+		page, err := parsePageRequest(query)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		cursorCreatedAt, cursorID := page.cursorArgs()
+		params := database.SearchChirpsParams{
+			Query: q,
+			AuthorID: authorID,
+			Since: since,
+			Until: until,
+			CursorCreatedAt: cursorCreatedAt,
+			CursorID: cursorID,
+			PageSize: int32(limit + 1),
+		}
+		if sortOrder != "desc" {
+			rows, err := cfg.db.SearchChirps(r.Context(), params)
+			if err != nil {
+				log.Printf("Error searching chirps for %q: %s", q, err)
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("Error searching chirps"))
+				return
+			}
+			chirps = chirpsFromRows(rows)
+		} else {
+			rows, err := cfg.db.SearchChirpsDesc(r.Context(), database.SearchChirpsDescParams(params))
+			if err != nil {
+				log.Printf("Error searching chirps for %q: %s", q, err)
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("Error searching chirps"))
+				return
+			}
+			chirps = chirpsFromRows(rows)
+		}
+		if len(chirps) > limit {
+			chirps = chirps[:limit]
+			last := chirps[len(chirps)-1]
+			results.NextCursor = encodeCursor(chirpCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+		}
+	}
+
+	results.Chirps, err = cfg.buildChirpResponses(r.Context(), chirps, cfg.optionalUserID(r))
+	if err != nil {
+		log.Printf("Error building search results for %q: %s", q, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error searching chirps"))
+		return
+	}
+	dst, err := json.Marshal(results)
+	if err != nil {
+		log.Printf("Error marshalling %v: %s", results, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error searching chirps"))
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dst)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/xsynch/chirpy/internal/database"
+)
+
+const maxUserAgentLength = 256
+
+// clientInfo is the user agent and address recorded against a refresh token.
+func clientInfo(r *http.Request) (string, string){
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return userAgent, ip
+}
+
+// getSessions lists the caller's active sessions. The id of a session is its
+// refresh token family, so it stays the same across refreshes.
+func (cfg *apiConfig) getSessions(w http.ResponseWriter, r *http.Request){
+	userID := authUserID(r)
+	results, err := cfg.db.GetUserSessions(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error getting sessions for %v: %s", userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	sessions := make([]session, 0, len(results))
+	for _, val := range results {
+		sessions = append(sessions, session{
+			ID: val.FamilyID,
+			UserAgent: val.UserAgent,
+			IPAddress: val.IpAddress,
+			CreatedAt: val.StartedAt,
+			LastUsedAt: val.LastUsedAt,
+			ExpiresAt: val.ExpiresAt,
+		})
+	}
+	dst, err := json.Marshal(sessions)
+	if err != nil {
+		log.Printf("Error marshalling sessions: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dst)
+}
+
+func (cfg *apiConfig) revokeSession(w http.ResponseWriter, r *http.Request){
+	userID := authUserID(r)
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid session id"))
+		return
+	}
+	revoked, err := cfg.db.RevokeUserSession(r.Context(), database.RevokeUserSessionParams{FamilyID: sessionID, UserID: userID})
+	if err != nil {
+		log.Printf("Error revoking session %v: %s", sessionID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	if revoked == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Session not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeAllSessions logs the caller out everywhere. Access tokens already
+// handed out keep working until they expire.
+func (cfg *apiConfig) revokeAllSessions(w http.ResponseWriter, r *http.Request){
+	userID := authUserID(r)
+	err := cfg.db.RevokeUserRefreshTokens(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error revoking sessions for %v: %s", userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
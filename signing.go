@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xsynch/chirpy/internal/database"
+	"github.com/xsynch/chirpy/internal/jwtkeys"
+)
+
+const defaultKeyRotation = 30 * 24 * time.Hour
+
+// signingKeyGrace is how long a retired key keeps verifying tokens. It must
+// outlive every access token signed before the rotation.
+const signingKeyGrace = 24 * time.Hour
+
+// keyReloadInterval limits how often a token with an unknown kid makes us
+// reload the keys, in case another instance has just rotated.
+const keyReloadInterval = time.Minute
+
+func (cfg *apiConfig) loadSigningKeys(ctx context.Context) error {
+	rows, err := cfg.db.GetSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+	keys := make([]jwtkeys.Key, 0, len(rows))
+	for _, val := range rows {
+		private, err := jwtkeys.ParsePrivate(val.Algorithm, val.PrivateKey)
+		if err != nil {
+			log.Printf("Skipping signing key %s: %s", val.Kid, err)
+			continue
+		}
+		key := jwtkeys.Key{ID: val.Kid, Algorithm: val.Algorithm, Private: private, CreatedAt: val.CreatedAt}
+		if val.ExpiresAt.Valid {
+			key.ExpiresAt = val.ExpiresAt.Time
+		}
+		keys = append(keys, key)
+	}
+	cfg.signingKeys.Replace(keys)
+	cfg.lastKeyReload.Store(time.Now().UnixNano())
+	return nil
+}
+
+// rotateSigningKeys creates a new signing key when there is none, when the
+// current one is older than the rotation interval or when the configured
+// algorithm has changed. The keys it replaces verify for signingKeyGrace.
+func (cfg *apiConfig) rotateSigningKeys(ctx context.Context) error {
+	err := cfg.loadSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+	current, ok := cfg.signingKeys.Current()
+	if ok && current.Algorithm == cfg.signing_alg && time.Since(current.CreatedAt) < cfg.key_rotation {
+		return cfg.db.DeleteExpiredSigningKeys(ctx)
+	}
+	key, err := jwtkeys.Generate(cfg.signing_alg, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	der, err := key.MarshalPrivate()
+	if err != nil {
+		return err
+	}
+	err = cfg.db.InsertSigningKey(ctx, database.InsertSigningKeyParams{Kid: key.ID, Algorithm: key.Algorithm, PrivateKey: der, CreatedAt: key.CreatedAt})
+	if err != nil {
+		return err
+	}
+	err = cfg.db.RetireSigningKeys(ctx, database.RetireSigningKeysParams{GraceSeconds: int64(signingKeyGrace.Seconds()), CurrentKid: key.ID})
+	if err != nil {
+		return err
+	}
+	log.Printf("Rotated JWT signing key, now signing with %s (%s)", key.ID, key.Algorithm)
+	err = cfg.db.DeleteExpiredSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+	return cfg.loadSigningKeys(ctx)
+}
+
+func (cfg *apiConfig) makeJWT(userID uuid.UUID, expiresIn time.Duration) (string, error){
+	return cfg.signingKeys.MakeJWT(userID, expiresIn)
+}
+
+func (cfg *apiConfig) validateJWT(token string) (uuid.UUID, error){
+	userID, err := cfg.signingKeys.ValidateJWT(token)
+	if !errors.Is(err, jwtkeys.ErrUnknownKey) {
+		return userID, err
+	}
+	last := cfg.lastKeyReload.Load()
+	if time.Since(time.Unix(0, last)) < keyReloadInterval || !cfg.lastKeyReload.CompareAndSwap(last, time.Now().UnixNano()) {
+		return userID, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	reloadErr := cfg.loadSigningKeys(ctx)
+	if reloadErr != nil {
+		log.Printf("Error reloading signing keys: %s", reloadErr)
+		return userID, err
+	}
+	return cfg.signingKeys.ValidateJWT(token)
+}
+
+// getJWKS publishes the public keys that verify Chirpy access tokens.
+func (cfg *apiConfig) getJWKS(w http.ResponseWriter, r *http.Request){
+	dst, err := json.Marshal(cfg.signingKeys.JWKS())
+	if err != nil {
+		log.Printf("Error marshalling JWKS: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.Header().Set("Cache-Control","public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dst)
+}
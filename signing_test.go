@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xsynch/chirpy/internal/jwtkeys"
+)
+
+// signingConfig is a config with its own key set over the test database's
+// signing keys.
+func signingConfig(t *testing.T, alg string) *apiConfig {
+	t.Helper()
+	cfg, _ := testConfig(t)
+	cfg.signingKeys = jwtkeys.NewSet("")
+	cfg.signing_alg = alg
+	cfg.key_rotation = defaultKeyRotation
+	return cfg
+}
+
+func hasKey(jwks jwtkeys.JWKS, kid string) bool {
+	for _, key := range jwks.Keys {
+		if key.KeyID == kid {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRotatedKeyVerifiesDuringGrace(t *testing.T) {
+	ctx := context.Background()
+	cfg := signingConfig(t, jwtkeys.EdDSA)
+	// a change of algorithm always rotates, whatever keys other tests left
+	cfg.signing_alg = jwtkeys.RS256
+	err := cfg.rotateSigningKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.signing_alg = jwtkeys.EdDSA
+	err = cfg.rotateSigningKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, ok := cfg.signingKeys.Current()
+	if !ok {
+		t.Fatal("no current key after rotating")
+	}
+	userID := uuid.New()
+	token, err := cfg.makeJWT(userID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.signing_alg = jwtkeys.RS256
+	err = cfg.rotateSigningKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := cfg.signingKeys.Current(); current.ID == old.ID {
+		t.Fatalf("still signing with %s after rotating", old.ID)
+	}
+
+	// a fresh instance only sees what the database says is in its grace
+	// period
+	other := signingConfig(t, jwtkeys.RS256)
+	err = other.loadSigningKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := other.validateJWT(token); err != nil || got != userID {
+		t.Errorf("token from the retired key = %v, %v, want %v", got, err, userID)
+	}
+	if !hasKey(other.signingKeys.JWKS(), old.ID) {
+		t.Errorf("retired key %s is missing from the JWKS during its grace period", old.ID)
+	}
+
+	_, err = cfg.sqlDB.ExecContext(ctx, "UPDATE signing_keys SET expires_at = NOW() - INTERVAL '1 second' WHERE kid = $1", old.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other = signingConfig(t, jwtkeys.RS256)
+	err = other.loadSigningKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.signingKeys.ValidateJWT(token); !errors.Is(err, jwtkeys.ErrUnknownKey) {
+		t.Errorf("token from an expired key error = %v, want ErrUnknownKey", err)
+	}
+	if hasKey(other.signingKeys.JWKS(), old.ID) {
+		t.Errorf("expired key %s is still in the JWKS", old.ID)
+	}
+}
@@ -0,0 +1,38 @@
+-- name: ScheduleUserDeletion :one
+UPDATE users
+SET deleted_at = NOW(), updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: RestoreUser :one
+UPDATE users
+SET deleted_at = NULL, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: GetUsersDueForPurge :many
+-- The cutoff is worked out against the database clock, the same one that
+-- set deleted_at.
+SELECT id FROM users
+WHERE deleted_at IS NOT NULL
+    AND deleted_at < NOW() - sqlc.arg('grace_seconds')::bigint * INTERVAL '1 second';
+
+-- name: IsUserActive :one
+SELECT EXISTS (
+    SELECT 1 FROM users
+    WHERE id = $1 AND deleted_at IS NULL
+);
+
+-- name: GetUserStorageKeys :many
+SELECT media.storage_key FROM media
+WHERE media.user_id = $1
+UNION ALL
+SELECT media_variants.storage_key FROM media_variants
+JOIN media ON media.id = media_variants.media_id
+WHERE media.user_id = $1;
+
+-- name: PurgeUser :exec
+-- Chirps, refresh tokens, likes, follows and media rows all reference users
+-- with ON DELETE CASCADE.
+DELETE FROM users
+WHERE id = $1 AND deleted_at IS NOT NULL;
@@ -0,0 +1,38 @@
+-- name: InsertChirpEntity :exec
+INSERT INTO chirp_entities (id, chirp_id, entity_type, text, value, user_id, start_offset, end_offset)
+VALUES (gen_random_uuid(), $1, $2, $3, $4, $5, $6, $7);
+
+-- name: DeleteChirpEntities :exec
+DELETE FROM chirp_entities
+WHERE chirp_id = $1;
+
+-- name: GetEntitiesForChirps :many
+SELECT * FROM chirp_entities
+WHERE chirp_id = ANY(sqlc.arg('chirp_ids')::uuid[])
+ORDER BY chirp_id, start_offset;
+
+-- name: GetChirpsByHashtag :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, quoted_chirp_id FROM chirps
+WHERE id IN (
+    SELECT chirp_id FROM chirp_entities
+    WHERE entity_type = 'hashtag' AND value = sqlc.arg('tag')
+)
+AND (
+    sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('page_size');
+
+-- name: GetChirpsMentioningUser :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, quoted_chirp_id FROM chirps
+WHERE id IN (
+    SELECT chirp_id FROM chirp_entities
+    WHERE entity_type = 'mention' AND user_id = sqlc.arg('user_id')
+)
+AND (
+    sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('page_size');
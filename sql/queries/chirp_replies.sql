@@ -0,0 +1,48 @@
+-- name: InsertChirpWithRefs :one
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, in_reply_to, quoted_chirp_id)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+)
+RETURNING id, created_at, updated_at, body, user_id, in_reply_to, quoted_chirp_id;
+
+-- name: GetReplyCounts :many
+SELECT in_reply_to::uuid AS chirp_id, COUNT(*) AS reply_count FROM chirps
+WHERE in_reply_to = ANY(sqlc.arg('chirp_ids')::uuid[])
+GROUP BY in_reply_to;
+
+-- name: GetChirpAncestors :many
+WITH RECURSIVE ancestors AS (
+    SELECT parent.id, parent.created_at, parent.updated_at, parent.body, parent.user_id, parent.in_reply_to, parent.quoted_chirp_id, 1 AS depth
+    FROM chirps parent
+    JOIN chirps child ON child.in_reply_to = parent.id
+    WHERE child.id = $1
+    UNION ALL
+    SELECT parent.id, parent.created_at, parent.updated_at, parent.body, parent.user_id, parent.in_reply_to, parent.quoted_chirp_id, ancestors.depth + 1
+    FROM chirps parent
+    JOIN ancestors ON ancestors.in_reply_to = parent.id
+)
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, quoted_chirp_id FROM ancestors
+ORDER BY depth DESC;
+
+-- name: GetChirpDescendants :many
+-- Replies are older than their own replies, so cutting the oldest max_rows
+-- never keeps a reply without its parent.
+WITH RECURSIVE descendants AS (
+    SELECT id, created_at, updated_at, body, user_id, in_reply_to, quoted_chirp_id, 1 AS depth
+    FROM chirps
+    WHERE chirps.in_reply_to = sqlc.arg('parent_id')::uuid
+    UNION ALL
+    SELECT reply.id, reply.created_at, reply.updated_at, reply.body, reply.user_id, reply.in_reply_to, reply.quoted_chirp_id, descendants.depth + 1
+    FROM chirps reply
+    JOIN descendants ON reply.in_reply_to = descendants.id
+    WHERE descendants.depth < sqlc.arg('max_depth')::int
+)
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, quoted_chirp_id FROM descendants
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('max_rows');
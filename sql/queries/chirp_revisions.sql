@@ -0,0 +1,16 @@
+-- name: UpdateChirpBody :one
+WITH revision AS (
+    INSERT INTO chirp_revisions (id, chirp_id, body, created_at, replaced_at)
+    SELECT gen_random_uuid(), chirps.id, chirps.body, chirps.updated_at, NOW()
+    FROM chirps
+    WHERE chirps.id = $1
+)
+UPDATE chirps
+SET body = $2, updated_at = NOW()
+WHERE chirps.id = $1
+RETURNING chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.in_reply_to, chirps.quoted_chirp_id;
+
+-- name: GetChirpRevisions :many
+SELECT * FROM chirp_revisions
+WHERE chirp_id = $1
+ORDER BY replaced_at ASC;
@@ -0,0 +1,44 @@
+-- name: SearchChirps :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, quoted_chirp_id FROM chirps
+WHERE search_vector @@ websearch_to_tsquery('english', sqlc.arg('query'))
+AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id')::uuid)
+AND (sqlc.narg('since')::timestamp IS NULL OR created_at >= sqlc.narg('since')::timestamp)
+AND (sqlc.narg('until')::timestamp IS NULL OR created_at < sqlc.narg('until')::timestamp)
+AND (
+    sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('page_size');
+
+-- name: SearchChirpsDesc :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, quoted_chirp_id FROM chirps
+WHERE search_vector @@ websearch_to_tsquery('english', sqlc.arg('query'))
+AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id')::uuid)
+AND (sqlc.narg('since')::timestamp IS NULL OR created_at >= sqlc.narg('since')::timestamp)
+AND (sqlc.narg('until')::timestamp IS NULL OR created_at < sqlc.narg('until')::timestamp)
+AND (
+    sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('page_size');
+
+-- name: SearchChirpsByRelevance :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, quoted_chirp_id,
+    ts_rank(search_vector, websearch_to_tsquery('english', sqlc.arg('query')))::real AS rank
+FROM chirps
+WHERE search_vector @@ websearch_to_tsquery('english', sqlc.arg('query'))
+AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id')::uuid)
+AND (sqlc.narg('since')::timestamp IS NULL OR created_at >= sqlc.narg('since')::timestamp)
+AND (sqlc.narg('until')::timestamp IS NULL OR created_at < sqlc.narg('until')::timestamp)
+AND (
+    sqlc.narg('cursor_rank')::real IS NULL
+    OR ts_rank(search_vector, websearch_to_tsquery('english', sqlc.arg('query')))::real < sqlc.narg('cursor_rank')::real
+    OR (
+        ts_rank(search_vector, websearch_to_tsquery('english', sqlc.arg('query')))::real = sqlc.narg('cursor_rank')::real
+        AND id > sqlc.narg('cursor_id')::uuid
+    )
+)
+ORDER BY rank DESC, id ASC
+LIMIT sqlc.arg('page_size');
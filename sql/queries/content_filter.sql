@@ -0,0 +1,28 @@
+-- name: GetFilterWords :many
+SELECT * FROM filter_words
+ORDER BY word;
+
+-- name: UpsertFilterWord :one
+INSERT INTO filter_words (word, action, created_at, updated_at)
+VALUES ($1, $2, NOW(), NOW())
+ON CONFLICT (word) DO UPDATE SET action = EXCLUDED.action, updated_at = NOW()
+RETURNING *;
+
+-- name: DeleteFilterWord :exec
+DELETE FROM filter_words
+WHERE word = $1;
+
+-- name: FlagChirpForReview :exec
+INSERT INTO chirp_reviews (chirp_id, matched_words, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (chirp_id) DO UPDATE SET matched_words = EXCLUDED.matched_words, created_at = NOW(), resolved_at = NULL;
+
+-- name: GetChirpsPendingReview :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.in_reply_to, chirps.quoted_chirp_id, chirp_reviews.matched_words, chirp_reviews.created_at AS flagged_at FROM chirp_reviews
+JOIN chirps ON chirps.id = chirp_reviews.chirp_id
+WHERE chirp_reviews.resolved_at IS NULL
+ORDER BY chirp_reviews.created_at ASC;
+
+-- name: ResolveChirpReview :exec
+UPDATE chirp_reviews SET resolved_at = NOW()
+WHERE chirp_id = $1 AND resolved_at IS NULL;
@@ -0,0 +1,64 @@
+-- name: InsertChirpyRedEvent :exec
+INSERT INTO chirpy_red_events (user_id, event, is_chirpy_red)
+VALUES ($1, $2, $3);
+
+-- name: GetChirpyRedEvents :many
+SELECT * FROM chirpy_red_events
+WHERE user_id = $1
+ORDER BY created_at;
+
+-- name: GetUserRefreshTokens :many
+SELECT * FROM refresh_tokens
+WHERE user_id = $1
+ORDER BY created_at;
+
+-- name: GetUserMedia :many
+SELECT * FROM media
+WHERE user_id = $1
+ORDER BY created_at;
+
+-- name: InsertDataExport :one
+INSERT INTO data_exports (id, user_id)
+VALUES ($1, $2)
+RETURNING *;
+
+-- name: GetPendingDataExport :one
+-- A pending export older than the build timeout was lost with the process
+-- building it, so it does not block a new request.
+SELECT * FROM data_exports
+WHERE user_id = sqlc.arg('user_id') AND status = 'pending'
+    AND created_at > NOW() - sqlc.arg('timeout_seconds')::bigint * INTERVAL '1 second'
+ORDER BY created_at DESC
+LIMIT 1;
+
+-- name: GetDataExport :one
+SELECT * FROM data_exports
+WHERE id = $1 AND user_id = $2;
+
+-- name: CompleteDataExport :exec
+UPDATE data_exports
+SET status = 'ready', storage_key = $2, size_bytes = $3, completed_at = NOW(), expires_at = $4
+WHERE id = $1;
+
+-- name: FailDataExport :exec
+UPDATE data_exports
+SET status = 'failed', completed_at = NOW()
+WHERE id = $1;
+
+-- name: FailStaleDataExports :execrows
+UPDATE data_exports
+SET status = 'failed', completed_at = NOW()
+WHERE status = 'pending'
+    AND created_at < NOW() - sqlc.arg('timeout_seconds')::bigint * INTERVAL '1 second';
+
+-- name: GetExpiredDataExports :many
+SELECT * FROM data_exports
+WHERE expires_at < NOW();
+
+-- name: DeleteDataExport :exec
+DELETE FROM data_exports
+WHERE id = $1;
+
+-- name: GetUserExportKeys :many
+SELECT storage_key::text FROM data_exports
+WHERE user_id = $1 AND storage_key IS NOT NULL;
@@ -0,0 +1,45 @@
+-- name: InsertMedia :one
+INSERT INTO media (id, user_id, storage_key, content_type, size_bytes, width, height, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+RETURNING *;
+
+-- name: GetMediaByIDs :many
+SELECT * FROM media
+WHERE id = ANY(sqlc.arg('ids')::uuid[]);
+
+-- name: AttachMediaToChirp :execrows
+UPDATE media SET chirp_id = sqlc.arg('chirp_id'), position = sqlc.arg('position')
+WHERE id = sqlc.arg('id') AND user_id = sqlc.arg('user_id') AND chirp_id IS NULL;
+
+-- name: GetMediaForChirps :many
+SELECT * FROM media
+WHERE chirp_id = ANY(sqlc.arg('chirp_ids')::uuid[])
+ORDER BY chirp_id, position;
+
+-- name: InsertMediaVariant :exec
+INSERT INTO media_variants (media_id, name, storage_key, content_type, width, height)
+VALUES ($1, $2, $3, $4, $5, $6);
+
+-- name: GetMediaVariants :many
+SELECT * FROM media_variants
+WHERE media_id = ANY(sqlc.arg('media_ids')::uuid[])
+ORDER BY media_id, name;
+
+-- name: GetChirpStorageKeys :many
+SELECT media.storage_key FROM media
+WHERE media.chirp_id = $1
+UNION ALL
+SELECT media_variants.storage_key FROM media_variants
+JOIN media ON media.id = media_variants.media_id
+WHERE media.chirp_id = $1;
+
+-- name: GetAbandonedMedia :many
+-- Avatars are never attached to a chirp, so they do not count as abandoned.
+SELECT * FROM media
+WHERE chirp_id IS NULL AND created_at < NOW() - INTERVAL '1 day'
+AND NOT EXISTS (SELECT 1 FROM users WHERE users.avatar_media_id = media.id);
+
+-- name: DeleteAbandonedMedium :execrows
+DELETE FROM media
+WHERE id = $1 AND chirp_id IS NULL
+AND NOT EXISTS (SELECT 1 FROM users WHERE users.avatar_media_id = media.id);
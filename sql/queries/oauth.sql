@@ -0,0 +1,65 @@
+-- name: InsertOAuthClient :one
+INSERT INTO oauth_clients (id, user_id, name, secret_hash, redirect_uris)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: GetOAuthClient :one
+SELECT * FROM oauth_clients
+WHERE id = $1;
+
+-- name: GetUserOAuthClients :many
+SELECT * FROM oauth_clients
+WHERE user_id = $1
+ORDER BY created_at DESC;
+
+-- name: DeleteOAuthClient :execrows
+DELETE FROM oauth_clients
+WHERE id = $1 AND user_id = $2;
+
+-- name: InsertOAuthCode :exec
+INSERT INTO oauth_codes (code_hash, grant_id, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, NOW() + sqlc.arg('lifetime_seconds')::bigint * INTERVAL '1 second');
+
+-- name: GetOAuthCode :one
+-- Expiry is checked here, on the clock that set it and that purges it.
+SELECT oauth_codes.*, oauth_codes.expires_at <= NOW() AS expired FROM oauth_codes
+WHERE code_hash = $1;
+
+-- name: ConsumeOAuthCode :execrows
+UPDATE oauth_codes
+SET used_at = NOW()
+WHERE code_hash = $1 AND used_at IS NULL;
+
+-- name: InsertOAuthToken :exec
+INSERT INTO oauth_tokens (token_hash, grant_id, kind, client_id, user_id, scopes, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, NOW() + sqlc.arg('lifetime_seconds')::bigint * INTERVAL '1 second');
+
+-- name: GetOAuthToken :one
+-- Expiry is checked here, on the clock that set it and that purges it.
+-- The stored times are in the database's zone, so the Unix times for
+-- introspection are worked out here too.
+SELECT oauth_tokens.*,
+    oauth_tokens.expires_at <= NOW() AS expired,
+    EXTRACT(EPOCH FROM oauth_tokens.created_at::timestamptz)::bigint AS issued_unix,
+    EXTRACT(EPOCH FROM oauth_tokens.expires_at::timestamptz)::bigint AS expires_unix
+FROM oauth_tokens
+JOIN users ON users.id = oauth_tokens.user_id
+WHERE oauth_tokens.token_hash = $1 AND users.deleted_at IS NULL;
+
+-- name: RotateOAuthRefreshToken :execrows
+UPDATE oauth_tokens
+SET revoked_at = NOW()
+WHERE token_hash = $1 AND kind = 'refresh' AND revoked_at IS NULL;
+
+-- name: RevokeOAuthGrant :exec
+UPDATE oauth_tokens
+SET revoked_at = NOW()
+WHERE grant_id = $1 AND revoked_at IS NULL;
+
+-- name: PurgeOAuthTokens :exec
+DELETE FROM oauth_tokens
+WHERE expires_at < NOW() OR revoked_at < NOW() - INTERVAL '1 day';
+
+-- name: PurgeOAuthCodes :exec
+DELETE FROM oauth_codes
+WHERE expires_at < NOW() - INTERVAL '1 day';
@@ -0,0 +1,25 @@
+-- name: InsertOIDCLoginState :exec
+INSERT INTO oidc_login_states (state_hash, provider, nonce, code_verifier, expires_at)
+VALUES ($1, $2, $3, $4, $5);
+
+-- name: ConsumeOIDCLoginState :one
+DELETE FROM oidc_login_states
+WHERE state_hash = $1 AND provider = $2
+RETURNING *;
+
+-- name: PurgeOIDCLoginStates :exec
+DELETE FROM oidc_login_states
+WHERE expires_at < NOW();
+
+-- name: GetUserIdentity :one
+SELECT * FROM user_identities
+WHERE provider = $1 AND subject = $2;
+
+-- name: InsertUserIdentity :exec
+INSERT INTO user_identities (provider, subject, user_id, email)
+VALUES ($1, $2, $3, $4);
+
+-- name: TouchUserIdentity :exec
+UPDATE user_identities
+SET last_login_at = NOW()
+WHERE provider = $1 AND subject = $2;
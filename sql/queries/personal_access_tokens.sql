@@ -0,0 +1,29 @@
+-- name: InsertPersonalAccessToken :one
+-- expires_in_days of 0 makes a token that never expires.
+INSERT INTO personal_access_tokens (id, user_id, name, token_hash, scopes, expires_at)
+VALUES ($1, $2, $3, $4, $5, CASE WHEN sqlc.arg('expires_in_days')::int > 0 THEN NOW() + make_interval(days => sqlc.arg('expires_in_days')::int) END)
+RETURNING *;
+
+-- name: GetPersonalAccessToken :one
+SELECT personal_access_tokens.* FROM personal_access_tokens
+JOIN users ON users.id = personal_access_tokens.user_id
+WHERE personal_access_tokens.token_hash = $1
+    AND personal_access_tokens.revoked_at IS NULL
+    AND (personal_access_tokens.expires_at IS NULL OR personal_access_tokens.expires_at > NOW())
+    AND users.deleted_at IS NULL;
+
+-- name: TouchPersonalAccessToken :exec
+-- Writes at most once a minute per token.
+UPDATE personal_access_tokens
+SET last_used_at = NOW()
+WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute');
+
+-- name: GetUserPersonalAccessTokens :many
+SELECT * FROM personal_access_tokens
+WHERE user_id = $1 AND revoked_at IS NULL
+ORDER BY created_at DESC;
+
+-- name: RevokePersonalAccessToken :execrows
+UPDATE personal_access_tokens
+SET revoked_at = NOW()
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;
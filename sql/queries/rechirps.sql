@@ -0,0 +1,46 @@
+-- name: GetChirpsByIDs :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, quoted_chirp_id FROM chirps
+WHERE id = ANY(sqlc.arg('ids')::uuid[]);
+
+-- name: InsertRechirp :exec
+INSERT INTO rechirps (id, user_id, chirp_id, created_at)
+VALUES (gen_random_uuid(), $1, $2, NOW())
+ON CONFLICT (user_id, chirp_id) DO NOTHING;
+
+-- name: DeleteRechirp :exec
+DELETE FROM rechirps
+WHERE user_id = $1 AND chirp_id = $2;
+
+-- name: GetRechirpsByUserPage :many
+SELECT sqlc.embed(rechirps), chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.in_reply_to, chirps.quoted_chirp_id FROM rechirps
+JOIN chirps ON chirps.id = rechirps.chirp_id
+WHERE rechirps.user_id = sqlc.arg('user_id')
+AND (
+    sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (rechirps.created_at, rechirps.id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY rechirps.created_at ASC, rechirps.id ASC
+LIMIT sqlc.arg('page_size');
+
+-- name: GetRechirpsByUserPageDesc :many
+SELECT sqlc.embed(rechirps), chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.in_reply_to, chirps.quoted_chirp_id FROM rechirps
+JOIN chirps ON chirps.id = rechirps.chirp_id
+WHERE rechirps.user_id = sqlc.arg('user_id')
+AND (
+    sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (rechirps.created_at, rechirps.id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY rechirps.created_at DESC, rechirps.id DESC
+LIMIT sqlc.arg('page_size');
+
+-- name: GetTimelineRechirps :many
+SELECT sqlc.embed(rechirps), chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.in_reply_to, chirps.quoted_chirp_id FROM rechirps
+JOIN chirps ON chirps.id = rechirps.chirp_id
+JOIN follows ON follows.followee_id = rechirps.user_id
+WHERE follows.follower_id = sqlc.arg('follower_id')
+AND (
+    sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (rechirps.created_at, rechirps.id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY rechirps.created_at DESC, rechirps.id DESC
+LIMIT sqlc.arg('page_size');
@@ -0,0 +1,28 @@
+-- name: InsertRotatedRefreshToken :one
+INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, revoked_at, family_id, user_agent, ip_address, last_used_at)
+VALUES ($1, NOW(), NOW(), $2, NOW() + INTERVAL '60 days', NULL, $3, $4, $5, NOW())
+RETURNING *;
+
+-- name: RotateRefreshToken :execrows
+-- Fails when the token was already used, which is how a replayed token is
+-- caught even when two requests race.
+UPDATE refresh_tokens
+SET revoked_at = NOW(), rotated_at = NOW(), last_used_at = NOW(), updated_at = NOW()
+WHERE token = $1 AND revoked_at IS NULL;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE family_id = $1 AND revoked_at IS NULL;
+
+-- name: PurgeRefreshTokens :execrows
+-- Rotated tokens are kept while their family is still in use so a replay
+-- can be detected, everything else goes once it is expired or revoked.
+DELETE FROM refresh_tokens
+WHERE expires_at < NOW()
+    OR (revoked_at IS NOT NULL AND NOT EXISTS (
+        SELECT 1 FROM refresh_tokens AS live
+        WHERE live.family_id = refresh_tokens.family_id
+            AND live.revoked_at IS NULL
+            AND live.expires_at > NOW()
+    ));
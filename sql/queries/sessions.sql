@@ -0,0 +1,17 @@
+-- name: InsertSessionRefreshToken :one
+INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, revoked_at, family_id, user_agent, ip_address, last_used_at)
+VALUES ($1, NOW(), NOW(), $2, NOW() + INTERVAL '60 days', NULL, gen_random_uuid(), $3, $4, NOW())
+RETURNING *;
+
+-- name: GetUserSessions :many
+-- A session is a refresh token family, described by its live token.
+SELECT live.family_id, live.user_agent, live.ip_address, live.last_used_at, live.expires_at,
+    (SELECT MIN(family.created_at) FROM refresh_tokens AS family WHERE family.family_id = live.family_id)::timestamp AS started_at
+FROM refresh_tokens AS live
+WHERE live.user_id = $1 AND live.revoked_at IS NULL AND live.expires_at > NOW()
+ORDER BY live.last_used_at DESC;
+
+-- name: RevokeUserSession :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE family_id = $1 AND user_id = $2 AND revoked_at IS NULL;
@@ -0,0 +1,17 @@
+-- name: InsertSigningKey :exec
+INSERT INTO signing_keys (kid, algorithm, private_key, created_at)
+VALUES ($1, $2, $3, $4);
+
+-- name: GetSigningKeys :many
+SELECT * FROM signing_keys
+WHERE expires_at IS NULL OR expires_at > NOW()
+ORDER BY created_at;
+
+-- name: RetireSigningKeys :exec
+UPDATE signing_keys
+SET expires_at = NOW() + sqlc.arg('grace_seconds')::bigint * INTERVAL '1 second'
+WHERE expires_at IS NULL AND kid <> sqlc.arg('current_kid')::text;
+
+-- name: DeleteExpiredSigningKeys :exec
+DELETE FROM signing_keys
+WHERE expires_at < NOW();
@@ -0,0 +1,74 @@
+-- name: SetTOTPSecret :exec
+UPDATE users
+SET totp_secret = $2, totp_enabled_at = NULL, totp_last_step = 0, updated_at = NOW()
+WHERE id = $1;
+
+-- name: EnableTOTP :exec
+UPDATE users
+SET totp_enabled_at = NOW(), totp_last_step = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: DisableTOTP :exec
+UPDATE users
+SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0, updated_at = NOW()
+WHERE id = $1;
+
+-- name: UseTOTPStep :execrows
+-- Only succeeds for a step later than the last one used, so a code cannot
+-- be replayed.
+UPDATE users
+SET totp_last_step = $2
+WHERE id = $1 AND totp_last_step < $2;
+
+-- name: ReserveTOTPAttempt :execrows
+-- Counts a code before it is checked, so requests made in parallel cannot
+-- all slip under the limit. The max_failures-th attempt locks two factor
+-- for lockout_seconds; no row is updated while it is locked.
+UPDATE users
+SET totp_failures = CASE WHEN totp_locked_until <= NOW() THEN 1 ELSE totp_failures + 1 END,
+    totp_locked_until = CASE
+        WHEN totp_locked_until <= NOW() THEN NULL
+        WHEN totp_failures + 1 >= sqlc.arg('max_failures')::int THEN NOW() + sqlc.arg('lockout_seconds')::bigint * INTERVAL '1 second'
+        ELSE totp_locked_until
+    END
+WHERE id = sqlc.arg('id') AND (totp_locked_until IS NULL OR totp_locked_until <= NOW());
+
+-- name: ResetTOTPFailures :exec
+UPDATE users
+SET totp_failures = 0, totp_locked_until = NULL
+WHERE id = $1;
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes
+WHERE user_id = $1;
+
+-- name: InsertRecoveryCode :exec
+INSERT INTO recovery_codes (code_hash, user_id)
+VALUES ($1, $2);
+
+-- name: UseRecoveryCode :execrows
+UPDATE recovery_codes
+SET used_at = NOW()
+WHERE code_hash = $1 AND user_id = $2 AND used_at IS NULL;
+
+-- name: InsertLoginChallenge :exec
+INSERT INTO login_challenges (token_hash, user_id, expires_at)
+VALUES ($1, $2, $3);
+
+-- name: GetLoginChallenge :one
+SELECT * FROM login_challenges
+WHERE token_hash = $1;
+
+-- name: RecordLoginChallengeAttempt :one
+UPDATE login_challenges
+SET attempts = attempts + 1
+WHERE token_hash = $1
+RETURNING attempts;
+
+-- name: DeleteLoginChallenge :exec
+DELETE FROM login_challenges
+WHERE token_hash = $1;
+
+-- name: PurgeLoginChallenges :exec
+DELETE FROM login_challenges
+WHERE expires_at < NOW();
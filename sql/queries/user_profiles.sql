@@ -0,0 +1,16 @@
+-- name: GetUserByHandle :one
+SELECT * FROM users
+WHERE LOWER(handle) = LOWER(sqlc.arg('handle')::text);
+
+-- name: UpdateUserProfile :one
+UPDATE users
+SET handle = $2, display_name = $3, bio = $4, avatar_media_id = $5, website = $6, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: GetAuthorsByIDs :many
+SELECT users.id, users.handle, users.display_name, COALESCE(media_variants.storage_key, media.storage_key) AS avatar_key
+FROM users
+LEFT JOIN media ON media.id = users.avatar_media_id
+LEFT JOIN media_variants ON media_variants.media_id = media.id AND media_variants.name = 'thumb'
+WHERE users.id = ANY(sqlc.arg('ids')::uuid[]);
@@ -0,0 +1,16 @@
+-- +goose Up
+ALTER TABLE chirps ADD COLUMN quoted_chirp_id UUID REFERENCES chirps(id) ON DELETE SET NULL;
+
+CREATE TABLE rechirps (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    chirp_id UUID NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE (user_id, chirp_id)
+);
+
+CREATE INDEX rechirps_user_id_created_at_id_idx ON rechirps (user_id, created_at, id);
+
+-- +goose Down
+DROP TABLE rechirps;
+ALTER TABLE chirps DROP COLUMN quoted_chirp_id;
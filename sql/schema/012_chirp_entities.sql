@@ -0,0 +1,18 @@
+-- +goose Up
+CREATE TABLE chirp_entities (
+    id UUID PRIMARY KEY,
+    chirp_id UUID NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+    entity_type TEXT NOT NULL CHECK (entity_type IN ('hashtag', 'mention', 'url')),
+    text TEXT NOT NULL,
+    value TEXT NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    start_offset INTEGER NOT NULL,
+    end_offset INTEGER NOT NULL
+);
+
+CREATE INDEX chirp_entities_chirp_id_idx ON chirp_entities (chirp_id);
+CREATE INDEX chirp_entities_type_value_idx ON chirp_entities (entity_type, value);
+CREATE INDEX chirp_entities_user_id_idx ON chirp_entities (user_id) WHERE user_id IS NOT NULL;
+
+-- +goose Down
+DROP TABLE chirp_entities;
@@ -0,0 +1,12 @@
+-- +goose Up
+-- Postgres keeps the search vector in step with the body, so no trigger is
+-- needed. Chirp queries list their columns so it is not sent back with
+-- every chirp.
+ALTER TABLE chirps
+ADD COLUMN search_vector TSVECTOR GENERATED ALWAYS AS (to_tsvector('english', body)) STORED;
+
+CREATE INDEX chirps_search_vector_idx ON chirps USING GIN (search_vector);
+
+-- +goose Down
+DROP INDEX chirps_search_vector_idx;
+ALTER TABLE chirps DROP COLUMN search_vector;
@@ -0,0 +1,18 @@
+-- +goose Up
+CREATE TABLE filter_words (
+    word TEXT PRIMARY KEY,
+    action TEXT NOT NULL CHECK (action IN ('mask', 'reject', 'flag')),
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE chirp_reviews (
+    chirp_id UUID PRIMARY KEY REFERENCES chirps(id) ON DELETE CASCADE,
+    matched_words TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    resolved_at TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE chirp_reviews;
+DROP TABLE filter_words;
@@ -0,0 +1,18 @@
+-- +goose Up
+CREATE TABLE media (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    storage_key TEXT NOT NULL UNIQUE,
+    content_type TEXT NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    chirp_id UUID REFERENCES chirps(id) ON DELETE CASCADE,
+    position INTEGER,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX media_chirp_id_idx ON media (chirp_id, position) WHERE chirp_id IS NOT NULL;
+
+-- +goose Down
+DROP TABLE media;
@@ -0,0 +1,18 @@
+-- +goose Up
+ALTER TABLE users
+    ADD COLUMN handle TEXT,
+    ADD COLUMN display_name TEXT NOT NULL DEFAULT '',
+    ADD COLUMN bio TEXT NOT NULL DEFAULT '',
+    ADD COLUMN avatar_media_id UUID REFERENCES media(id) ON DELETE SET NULL,
+    ADD COLUMN website TEXT NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX users_handle_idx ON users (LOWER(handle));
+
+-- +goose Down
+DROP INDEX users_handle_idx;
+ALTER TABLE users
+    DROP COLUMN website,
+    DROP COLUMN avatar_media_id,
+    DROP COLUMN bio,
+    DROP COLUMN display_name,
+    DROP COLUMN handle;
@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE chirpy_red_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    event TEXT NOT NULL,
+    is_chirpy_red BOOLEAN NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX chirpy_red_events_user_idx ON chirpy_red_events (user_id, created_at);
+
+-- +goose Down
+DROP TABLE chirpy_red_events;
@@ -0,0 +1,16 @@
+-- +goose Up
+CREATE TABLE data_exports (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status TEXT NOT NULL DEFAULT 'pending',
+    storage_key TEXT,
+    size_bytes BIGINT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMP,
+    expires_at TIMESTAMP
+);
+
+CREATE INDEX data_exports_user_idx ON data_exports (user_id, created_at);
+
+-- +goose Down
+DROP TABLE data_exports;
@@ -0,0 +1,36 @@
+-- +goose Up
+ALTER TABLE users
+    ADD COLUMN totp_secret TEXT,
+    ADD COLUMN totp_enabled_at TIMESTAMP,
+    ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0,
+    -- codes tried since the last good one, across all of the user's login
+    -- challenges, so starting a new challenge does not buy more guesses
+    ADD COLUMN totp_failures INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN totp_locked_until TIMESTAMP;
+
+CREATE TABLE recovery_codes (
+    code_hash TEXT PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    used_at TIMESTAMP
+);
+
+CREATE INDEX recovery_codes_user_idx ON recovery_codes (user_id);
+
+CREATE TABLE login_challenges (
+    token_hash TEXT PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0
+);
+
+-- +goose Down
+DROP TABLE login_challenges;
+DROP TABLE recovery_codes;
+ALTER TABLE users
+    DROP COLUMN totp_locked_until,
+    DROP COLUMN totp_failures,
+    DROP COLUMN totp_last_step,
+    DROP COLUMN totp_enabled_at,
+    DROP COLUMN totp_secret;
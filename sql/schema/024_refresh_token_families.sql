@@ -0,0 +1,14 @@
+-- +goose Up
+-- Every refresh token belongs to a family: the token issued at login and
+-- every token rotated from it. Existing tokens each start their own family.
+ALTER TABLE refresh_tokens
+    ADD COLUMN family_id UUID NOT NULL DEFAULT gen_random_uuid(),
+    ADD COLUMN rotated_at TIMESTAMP;
+
+CREATE INDEX refresh_tokens_family_idx ON refresh_tokens (family_id);
+
+-- +goose Down
+DROP INDEX refresh_tokens_family_idx;
+ALTER TABLE refresh_tokens
+    DROP COLUMN rotated_at,
+    DROP COLUMN family_id;
@@ -0,0 +1,11 @@
+-- +goose Up
+-- Refresh tokens are stored as the hex SHA-256 of the token handed to the
+-- client, the same digest the server computes on lookup.
+UPDATE refresh_tokens SET token = encode(sha256(convert_to(token, 'UTF8')), 'hex');
+
+CREATE INDEX refresh_tokens_expires_at_idx ON refresh_tokens (expires_at);
+
+-- +goose Down
+-- The digests cannot be reversed, so every session is ended instead.
+DROP INDEX refresh_tokens_expires_at_idx;
+DELETE FROM refresh_tokens;
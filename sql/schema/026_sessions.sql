@@ -0,0 +1,14 @@
+-- +goose Up
+ALTER TABLE refresh_tokens
+    ADD COLUMN user_agent TEXT NOT NULL DEFAULT '',
+    ADD COLUMN ip_address TEXT NOT NULL DEFAULT '',
+    ADD COLUMN last_used_at TIMESTAMP NOT NULL DEFAULT NOW();
+
+CREATE INDEX refresh_tokens_user_idx ON refresh_tokens (user_id) WHERE revoked_at IS NULL;
+
+-- +goose Down
+DROP INDEX refresh_tokens_user_idx;
+ALTER TABLE refresh_tokens
+    DROP COLUMN last_used_at,
+    DROP COLUMN ip_address,
+    DROP COLUMN user_agent;
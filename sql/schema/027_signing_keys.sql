@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE signing_keys (
+    kid TEXT PRIMARY KEY,
+    algorithm TEXT NOT NULL,
+    private_key BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    -- NULL while the key is current, set when it is retired
+    expires_at TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE signing_keys;
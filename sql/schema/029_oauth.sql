@@ -0,0 +1,46 @@
+-- +goose Up
+CREATE TABLE oauth_clients (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    -- NULL for public clients such as mobile and single page apps
+    secret_hash TEXT,
+    redirect_uris TEXT[] NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX oauth_clients_user_idx ON oauth_clients (user_id);
+
+-- A grant is one approval of a client by a user. Its code, access tokens
+-- and refresh tokens share the grant id so they can be revoked together.
+CREATE TABLE oauth_codes (
+    code_hash TEXT PRIMARY KEY,
+    grant_id UUID NOT NULL,
+    client_id UUID NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    scopes TEXT[] NOT NULL,
+    code_challenge TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+CREATE TABLE oauth_tokens (
+    token_hash TEXT PRIMARY KEY,
+    grant_id UUID NOT NULL,
+    kind TEXT NOT NULL,
+    client_id UUID NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    scopes TEXT[] NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX oauth_tokens_grant_idx ON oauth_tokens (grant_id);
+
+-- +goose Down
+DROP TABLE oauth_tokens;
+DROP TABLE oauth_codes;
+DROP TABLE oauth_clients;
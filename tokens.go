@@ -0,0 +1,27 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// newOneTimeToken returns a random token to hand to the user and the digest
+// to store, so a leaked table cannot be replayed.
+func newOneTimeToken() (string, string, error){
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+// hashToken is the hex SHA-256 of token. Refresh tokens are stored the same
+// way, see sql/schema/025_hashed_refresh_tokens.sql.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xsynch/chirpy/internal/auth"
+	"github.com/xsynch/chirpy/internal/database"
+	"github.com/xsynch/chirpy/internal/totp"
+)
+
+const totpIssuer = "Chirpy"
+const recoveryCodeCount = 10
+const loginChallengeTTL = 5 * time.Minute
+const maxLoginChallengeAttempts = 5
+
+// maxTOTPFailures codes without a right one lock a user's second factor for
+// totpLockout, whichever challenge or form they were sent to.
+const maxTOTPFailures = 10
+const totpLockout = 15 * time.Minute
+
+var errTwoFactorLocked = errors.New("too many incorrect codes, try again later")
+
+// enrollTwoFactor creates a new TOTP secret and recovery codes. Two factor
+// login only starts once confirmTwoFactor has seen a code from the app.
+func (cfg *apiConfig) enrollTwoFactor(w http.ResponseWriter, r *http.Request){
+	user, ok := cfg.twoFactorUser(w, r)
+	if !ok {
+		return
+	}
+	request := struct {
+		Password string `json:"password"`
+	}{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&request)
+	if err != nil || auth.CheckPasswordHash(request.Password, user.HashedPassword) != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Incorrect password"))
+		return
+	}
+	if user.TotpEnabledAt.Valid {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Two factor authentication is already enabled"))
+		return
+	}
+	secret, err := totp.NewSecret()
+	if err != nil {
+		log.Printf("Error creating TOTP secret: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	err = cfg.db.SetTOTPSecret(r.Context(), database.SetTOTPSecretParams{ID: user.ID, TotpSecret: sql.NullString{String: totp.EncodeSecret(secret), Valid: true}})
+	if err != nil {
+		log.Printf("Error saving TOTP secret for %v: %s", user.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	codes, err := cfg.replaceRecoveryCodes(r.Context(), user)
+	if err != nil {
+		log.Printf("Error creating recovery codes for %v: %s", user.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	enrollment := twoFactorEnrollment{
+		OtpauthURI: totp.URI(totpIssuer, user.Email, secret),
+		Secret: totp.EncodeSecret(secret),
+		RecoveryCodes: codes,
+	}
+	dst, err := json.Marshal(enrollment)
+	if err != nil {
+		log.Printf("Error marshalling enrollment: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dst)
+}
+
+func (cfg *apiConfig) confirmTwoFactor(w http.ResponseWriter, r *http.Request){
+	user, ok := cfg.twoFactorUser(w, r)
+	if !ok {
+		return
+	}
+	request := struct {
+		Code string `json:"code"`
+	}{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&request)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Data Error"))
+		return
+	}
+	if !user.TotpSecret.Valid || user.TotpEnabledAt.Valid {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("No two factor enrollment is pending"))
+		return
+	}
+	secret, err := totp.DecodeSecret(user.TotpSecret.String)
+	if err != nil {
+		log.Printf("Error decoding TOTP secret for %v: %s", user.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	step, valid := totp.Validate(secret, request.Code, cfg.now(), 1)
+	if !valid {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Invalid code"))
+		return
+	}
+	err = cfg.db.EnableTOTP(r.Context(), database.EnableTOTPParams{ID: user.ID, TotpLastStep: step})
+	if err != nil {
+		log.Printf("Error enabling TOTP for %v: %s", user.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// disableTwoFactor needs the password and a current code or recovery code.
+func (cfg *apiConfig) disableTwoFactor(w http.ResponseWriter, r *http.Request){
+	user, ok := cfg.twoFactorUser(w, r)
+	if !ok {
+		return
+	}
+	request := struct {
+		Password string `json:"password"`
+		Code string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&request)
+	if err != nil || auth.CheckPasswordHash(request.Password, user.HashedPassword) != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Incorrect password"))
+		return
+	}
+	if user.TotpEnabledAt.Valid {
+		valid, err := cfg.checkSecondFactor(r.Context(), user, request.Code, request.RecoveryCode)
+		if errors.Is(err, errTwoFactorLocked) {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		if err != nil {
+			log.Printf("Error checking second factor for %v: %s", user.ID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Server Error, please try again"))
+			return
+		}
+		if !valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Invalid code"))
+			return
+		}
+	}
+	err = cfg.db.DisableTOTP(r.Context(), user.ID)
+	if err == nil {
+		err = cfg.db.DeleteRecoveryCodes(r.Context(), user.ID)
+	}
+	if err != nil {
+		log.Printf("Error disabling TOTP for %v: %s", user.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// startLoginChallenge is the response to a correct password when two factor
+// is on: a short lived token that completeLoginChallenge trades, together
+// with a code, for the real tokens.
+func (cfg *apiConfig) startLoginChallenge(w http.ResponseWriter, r *http.Request, user database.User){
+	token, tokenHash, err := newOneTimeToken()
+	if err != nil {
+		log.Printf("Error creating login challenge: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, Please try again"))
+		return
+	}
+	expiresAt := cfg.now().UTC().Add(loginChallengeTTL)
+	err = cfg.db.InsertLoginChallenge(r.Context(), database.InsertLoginChallengeParams{TokenHash: tokenHash, UserID: user.ID, ExpiresAt: expiresAt})
+	if err != nil {
+		log.Printf("Error saving login challenge for %v: %s", user.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, Please try again"))
+		return
+	}
+	challenge := loginChallenge{TwoFactorRequired: true, ChallengeToken: token, ExpiresAt: expiresAt}
+	dst, err := json.Marshal(challenge)
+	if err != nil {
+		log.Printf("Error marshalling challenge: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dst)
+}
+
+// challengeResponse is the second step of a login or account restore that
+// started with startLoginChallenge.
+type challengeResponse struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func (cfg *apiConfig) completeLoginChallenge(w http.ResponseWriter, r *http.Request){
+	request := challengeResponse{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&request)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Data Error"))
+		return
+	}
+	user, ok := cfg.redeemLoginChallenge(w, r, request)
+	if !ok {
+		return
+	}
+	// the account may have been deleted since the password was checked
+	if user.DeletedAt.Valid {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Account is scheduled for deletion, restore it with POST /api/users/restore"))
+		return
+	}
+	cfg.issueSession(w, r, user)
+}
+
+// redeemLoginChallenge checks the code sent for a challenge and returns the
+// user who passed it. It writes the error response itself when the
+// challenge or code is not valid.
+func (cfg *apiConfig) redeemLoginChallenge(w http.ResponseWriter, r *http.Request, request challengeResponse) (database.User, bool){
+	tokenHash := hashToken(request.ChallengeToken)
+	challenge, err := cfg.db.GetLoginChallenge(r.Context(), tokenHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error looking up login challenge: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, Please try again"))
+		return database.User{}, false
+	}
+	if err != nil || !challenge.ExpiresAt.After(cfg.now()) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Login challenge is invalid or has expired"))
+		return database.User{}, false
+	}
+	user, err := cfg.db.GetUserByID(r.Context(), challenge.UserID)
+	if err != nil {
+		log.Printf("Error looking up user %v: %s", challenge.UserID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, Please try again"))
+		return database.User{}, false
+	}
+	valid, err := cfg.checkSecondFactor(r.Context(), user, request.Code, request.RecoveryCode)
+	if errors.Is(err, errTwoFactorLocked) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(err.Error()))
+		return database.User{}, false
+	}
+	if err != nil {
+		log.Printf("Error checking second factor for %v: %s", user.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, Please try again"))
+		return database.User{}, false
+	}
+	if !valid {
+		attempts, err := cfg.db.RecordLoginChallengeAttempt(r.Context(), tokenHash)
+		if err == nil && attempts >= maxLoginChallengeAttempts {
+			err = cfg.db.DeleteLoginChallenge(r.Context(), tokenHash)
+		}
+		if err != nil {
+			log.Printf("Error recording login attempt for %v: %s", user.ID, err)
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Invalid code"))
+		return database.User{}, false
+	}
+	err = cfg.db.DeleteLoginChallenge(r.Context(), tokenHash)
+	if err != nil {
+		log.Printf("Error deleting login challenge for %v: %s", user.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, Please try again"))
+		return database.User{}, false
+	}
+	return user, true
+}
+
+// twoFactorUser loads the caller, writing the error response itself when
+// the user cannot be found.
+func (cfg *apiConfig) twoFactorUser(w http.ResponseWriter, r *http.Request) (database.User, bool){
+	userID := authUserID(r)
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error looking up user %v: %s", userID, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Invalid User"))
+		return database.User{}, false
+	}
+	return user, true
+}
+
+// checkSecondFactor accepts either a TOTP code, which may only be used
+// once, or an unused recovery code. Every code counts towards the user's
+// lockout until one is right, and errTwoFactorLocked is returned without
+// looking at the code while the lockout lasts.
+func (cfg *apiConfig) checkSecondFactor(ctx context.Context, user database.User, code string, recoveryCode string) (bool, error){
+	reserved, err := cfg.db.ReserveTOTPAttempt(ctx, database.ReserveTOTPAttemptParams{
+		ID: user.ID,
+		MaxFailures: maxTOTPFailures,
+		LockoutSeconds: int64(totpLockout.Seconds()),
+	})
+	if err != nil {
+		return false, err
+	}
+	if reserved == 0 {
+		return false, errTwoFactorLocked
+	}
+	valid, err := cfg.verifySecondFactor(ctx, user, code, recoveryCode)
+	if err != nil || !valid {
+		return false, err
+	}
+	return true, cfg.db.ResetTOTPFailures(ctx, user.ID)
+}
+
+func (cfg *apiConfig) verifySecondFactor(ctx context.Context, user database.User, code string, recoveryCode string) (bool, error){
+	if recoveryCode != "" {
+		rows, err := cfg.db.UseRecoveryCode(ctx, database.UseRecoveryCodeParams{CodeHash: hashToken(normaliseRecoveryCode(recoveryCode)), UserID: user.ID})
+		return rows == 1, err
+	}
+	if !user.TotpSecret.Valid || code == "" {
+		return false, nil
+	}
+	secret, err := totp.DecodeSecret(user.TotpSecret.String)
+	if err != nil {
+		return false, err
+	}
+	step, valid := totp.Validate(secret, code, cfg.now(), 1)
+	if !valid {
+		return false, nil
+	}
+	rows, err := cfg.db.UseTOTPStep(ctx, database.UseTOTPStepParams{ID: user.ID, TotpLastStep: step})
+	return rows == 1, err
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// replaceRecoveryCodes invalidates the user's old recovery codes and
+// returns new ones. Only their digests are stored.
+func (cfg *apiConfig) replaceRecoveryCodes(ctx context.Context, user database.User) ([]string, error){
+	err := cfg.db.DeleteRecoveryCodes(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 7)
+		_, err := rand.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(recoveryEncoding.EncodeToString(buf))[:10]
+		err = cfg.db.InsertRecoveryCode(ctx, database.InsertRecoveryCodeParams{CodeHash: hashToken(code), UserID: user.ID})
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code[:5] + "-" + code[5:])
+	}
+	return codes, nil
+}
+
+func normaliseRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xsynch/chirpy/internal/database"
+	"github.com/xsynch/chirpy/internal/totp"
+)
+
+// testConfig connects to the migrated database in TEST_DB_URL and returns a
+// config whose clock the test controls.
+func testConfig(t *testing.T) (*apiConfig, *time.Time) {
+	t.Helper()
+	dbURL := os.Getenv("TEST_DB_URL")
+	if dbURL == "" {
+		t.Skip("TEST_DB_URL is not set")
+	}
+	db, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	clock := time.Now()
+	cfg := &apiConfig{db: database.New(db), sqlDB: db, now: func() time.Time { return clock }}
+	return cfg, &clock
+}
+
+// newTwoFactorUser creates a user with TOTP enabled and returns them with
+// their secret.
+func newTwoFactorUser(t *testing.T, cfg *apiConfig) (database.User, []byte) {
+	t.Helper()
+	ctx := context.Background()
+	user, err := cfg.db.CreateUser(ctx, database.CreateUserParams{Email: uuid.NewString() + "@example.com", HashedPassword: "unused"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := totp.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.db.SetTOTPSecret(ctx, database.SetTOTPSecretParams{ID: user.ID, TotpSecret: sql.NullString{String: totp.EncodeSecret(secret), Valid: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.db.EnableTOTP(ctx, database.EnableTOTPParams{ID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reloadUser(t, cfg, user.ID), secret
+}
+
+func reloadUser(t *testing.T, cfg *apiConfig, id uuid.UUID) database.User {
+	t.Helper()
+	user, err := cfg.db.GetUserByID(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func startChallenge(t *testing.T, cfg *apiConfig, user database.User) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	cfg.startLoginChallenge(w, httptest.NewRequest(http.MethodPost, "/api/login", nil), user)
+	challenge := loginChallenge{}
+	err := json.Unmarshal(w.Body.Bytes(), &challenge)
+	if err != nil || challenge.ChallengeToken == "" {
+		t.Fatalf("startLoginChallenge returned %d %q", w.Code, w.Body.String())
+	}
+	return challenge.ChallengeToken
+}
+
+func completeChallenge(cfg *apiConfig, token string, code string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(challengeResponse{ChallengeToken: token, Code: code})
+	w := httptest.NewRecorder()
+	cfg.completeLoginChallenge(w, httptest.NewRequest(http.MethodPost, "/api/login/2fa", bytes.NewReader(body)))
+	return w
+}
+
+func TestCheckSecondFactorRejectsReplay(t *testing.T) {
+	cfg, clock := testConfig(t)
+	user, secret := newTwoFactorUser(t, cfg)
+	code := totp.Code(secret, totp.Step(*clock))
+	valid, err := cfg.checkSecondFactor(context.Background(), user, code, "")
+	if err != nil || !valid {
+		t.Fatalf("first use of a code = %v, %v, want true", valid, err)
+	}
+	valid, err = cfg.checkSecondFactor(context.Background(), reloadUser(t, cfg, user.ID), code, "")
+	if err != nil || valid {
+		t.Errorf("replayed code = %v, %v, want false", valid, err)
+	}
+	// an older code from within the skew window is a replay too
+	older := totp.Code(secret, totp.Step(*clock)-1)
+	valid, err = cfg.checkSecondFactor(context.Background(), reloadUser(t, cfg, user.ID), older, "")
+	if err != nil || valid {
+		t.Errorf("code for an earlier step = %v, %v, want false", valid, err)
+	}
+}
+
+func TestLoginChallengeExpires(t *testing.T) {
+	cfg, clock := testConfig(t)
+	user, secret := newTwoFactorUser(t, cfg)
+	token := startChallenge(t, cfg, user)
+	*clock = clock.Add(loginChallengeTTL + time.Second)
+	w := completeChallenge(cfg, token, totp.Code(secret, totp.Step(*clock)))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expired challenge returned %d %q, want 401", w.Code, w.Body.String())
+	}
+}
+
+func TestLoginChallengeAttempts(t *testing.T) {
+	cfg, clock := testConfig(t)
+	user, secret := newTwoFactorUser(t, cfg)
+	token := startChallenge(t, cfg, user)
+	for i := 0; i < maxLoginChallengeAttempts; i++ {
+		w := completeChallenge(cfg, token, "000000")
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong code %d returned %d, want 401", i, w.Code)
+		}
+	}
+	w := completeChallenge(cfg, token, totp.Code(secret, totp.Step(*clock)))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("right code after %d wrong ones returned %d, want 401", maxLoginChallengeAttempts, w.Code)
+	}
+}
+
+func TestTwoFactorLockoutSpansChallenges(t *testing.T) {
+	cfg, clock := testConfig(t)
+	user, secret := newTwoFactorUser(t, cfg)
+	// a fresh challenge per login must not reset the count
+	for i := 0; i < maxTOTPFailures; i++ {
+		w := completeChallenge(cfg, startChallenge(t, cfg, user), "000000")
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong code %d returned %d, want 401", i, w.Code)
+		}
+	}
+	w := completeChallenge(cfg, startChallenge(t, cfg, user), totp.Code(secret, totp.Step(*clock)))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("right code while locked returned %d, want 429", w.Code)
+	}
+	// the lockout runs on the database clock
+	_, err := cfg.sqlDB.ExecContext(context.Background(), "UPDATE users SET totp_locked_until = NOW() - INTERVAL '1 second' WHERE id = $1", user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, err := cfg.checkSecondFactor(context.Background(), reloadUser(t, cfg, user.ID), totp.Code(secret, totp.Step(*clock)), "")
+	if err != nil || !valid {
+		t.Errorf("right code after the lockout = %v, %v, want true", valid, err)
+	}
+	if got := reloadUser(t, cfg, user.ID); got.TotpFailures != 0 || got.TotpLockedUntil.Valid {
+		t.Errorf("after a right code failures = %d, locked until %v, want both cleared", got.TotpFailures, got.TotpLockedUntil)
+	}
+}
+
+func TestTwoFactorLockoutHoldsForParallelGuesses(t *testing.T) {
+	cfg, _ := testConfig(t)
+	user, _ := newTwoFactorUser(t, cfg)
+	guesses := 3 * maxTOTPFailures
+	results := make(chan error, guesses)
+	var wg sync.WaitGroup
+	for i := 0; i < guesses; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cfg.checkSecondFactor(context.Background(), user, "000000", "")
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+	checked := 0
+	for err := range results {
+		if err == nil {
+			checked++
+		} else if !errors.Is(err, errTwoFactorLocked) {
+			t.Fatal(err)
+		}
+	}
+	if checked != maxTOTPFailures {
+		t.Errorf("%d of %d parallel guesses were checked, want %d", checked, guesses, maxTOTPFailures)
+	}
+}
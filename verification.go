@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/xsynch/chirpy/internal/database"
+	"github.com/xsynch/chirpy/internal/mailer"
+)
+
+const emailVerificationTTL = 24 * time.Hour
+
+// validEmail accepts a bare address like user@example.com, without a
+// display name.
+func validEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
+// sendVerificationEmail replaces any outstanding token for the user with a
+// fresh one for email and mails it.
+func (cfg *apiConfig) sendVerificationEmail(ctx context.Context, userID uuid.UUID, email string) error {
+	token, tokenHash, err := newOneTimeToken()
+	if err != nil {
+		return err
+	}
+	err = cfg.db.DeleteEmailVerificationTokens(ctx, userID)
+	if err != nil {
+		return err
+	}
+	err = cfg.db.InsertEmailVerificationToken(ctx, database.InsertEmailVerificationTokenParams{
+		TokenHash: tokenHash,
+		UserID: userID,
+		Email: email,
+		TtlSeconds: int64(emailVerificationTTL.Seconds()),
+	})
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf("Confirm your email address by sending this token to %s/api/users/verify:\n\n%s\n\nThe token expires in 24 hours. If you did not ask for this, you can ignore this email.\n", cfg.public_url, token)
+	return cfg.mailer.Send(ctx, mailer.Message{To: email, Subject: "Confirm your Chirpy email address", Body: body})
+}
+
+func (cfg *apiConfig) verifyEmail(w http.ResponseWriter, r *http.Request){
+	request := struct {
+		Token string `json:"token"`
+	}{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&request)
+	if err != nil || request.Token == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("A verification token is required"))
+		return
+	}
+	token, err := cfg.db.ConsumeEmailVerificationToken(r.Context(), hashToken(request.Token))
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid or expired verification token"))
+		return
+	}
+	if err != nil {
+		log.Printf("Error consuming verification token: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	// for an email change this is where the new address replaces the old one
+	user, err := cfg.db.MarkEmailVerified(r.Context(), database.MarkEmailVerifiedParams{ID: token.UserID, Email: token.Email})
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid or expired verification token"))
+		return
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Email address is already in use"))
+		return
+	}
+	if err != nil {
+		log.Printf("Error verifying email for %v: %s", token.UserID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Server Error, please try again"))
+		return
+	}
+	dbuser := createDBUserResponse{
+		ID: user.ID,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+		Email: user.Email,
+		IsChirpyRd: user.IsChirpyRed,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+	}
+	dst, err := json.Marshal(dbuser)
+	if err != nil {
+		log.Printf("Error marshalling %v: %s", dbuser, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type","application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dst)
+}
+
+func (cfg *apiConfig) resendVerification(w http.ResponseWriter, r *http.Request){
+	userID := authUserID(r)
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error looking up user %v: %s", userID, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Invalid User"))
+		return
+	}
+	if user.EmailVerifiedAt.Valid {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Email address is already verified"))
+		return
+	}
+	err = cfg.sendVerificationEmail(r.Context(), user.ID, user.Email)
+	if err != nil {
+		log.Printf("Error sending verification email to %v: %s", user.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Error sending verification email, please try again"))
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// notifyEmailChange tells the current address that a change was requested,
+// so the owner notices if someone else is using their session.
+func (cfg *apiConfig) notifyEmailChange(ctx context.Context, oldEmail string, newEmail string) {
+	body := fmt.Sprintf("Someone asked to change the email address of your Chirpy account to %s.\n\nThe change only takes effect once the new address is confirmed. If this was not you, reset your password at %s/api/password/forgot.\n", newEmail, cfg.public_url)
+	err := cfg.mailer.Send(ctx, mailer.Message{To: oldEmail, Subject: "Your Chirpy email address is changing", Body: body})
+	if err != nil {
+		log.Printf("Error sending email change notice: %s", err)
+	}
+}